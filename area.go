@@ -0,0 +1,152 @@
+package mess
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// AreaPackage is a place & everything in it, as exported for installing in another mess.
+type AreaPackage struct {
+	Version int `json:"version"`
+	// Area is the id of the packaged place, which the rest of Things are inside.
+	Area   ThingId      `json:"area"`
+	Things []*ThingDump `json:"things"`
+}
+
+// PackageArea collects the place and what's in it (its contents & actions, and theirs in turn) into a package, as the given player sees it. Players aren't packaged, nor are things the player can't edit, along with whatever is inside them. Exits that lead out of the place are packaged, but their targets aren't, so they'll be left without targets when installed.
+func PackageArea(place *Thing, playerId ThingId) (*AreaPackage, error) {
+	if place.Type != PlaceThing {
+		return nil, &StoreError{StoreConstraintViolation, errors.New("only places can be packaged as areas")}
+	}
+	if place.Parent == 0 {
+		return nil, &StoreError{StoreConstraintViolation, errors.New("the first place holds the whole world, so it can't be packaged as an area")}
+	}
+	if !place.EditableById(playerId) {
+		return nil, &StoreError{StoreConstraintViolation, errors.New("you can only package areas you can edit")}
+	}
+
+	pkg := &AreaPackage{Version: DumpVersion, Area: place.Id}
+	queue := []*Thing{place}
+	for len(queue) > 0 {
+		thing := queue[0]
+		queue = queue[1:]
+
+		thingDump, err := dumpThing(thing)
+		if err != nil {
+			return nil, &StoreError{StoreConstraintViolation, fmt.Errorf("couldn't package #%d: %s", thing.Id, err.Error())}
+		}
+		pkg.Things = append(pkg.Things, thingDump)
+
+		for _, content := range append(thing.GetContents(), thing.GetActions()...) {
+			if content.Type != PlayerThing && content.EditableById(playerId) {
+				queue = append(queue, content)
+			}
+		}
+	}
+	return pkg, nil
+}
+
+// WriteTo writes the package to w as indented JSON.
+func (pkg *AreaPackage) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(pkg, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	data = append(data, '\n')
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// ReadAreaPackage reads a package written by AreaPackage.WriteTo.
+func ReadAreaPackage(r io.Reader) (*AreaPackage, error) {
+	pkg := &AreaPackage{}
+	err := json.NewDecoder(r).Decode(pkg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the area package: %s", err.Error())
+	}
+	if pkg.Version > DumpVersion {
+		return nil, fmt.Errorf("the area package is version %d, but this mess can only install up to version %d", pkg.Version, DumpVersion)
+	}
+	found := false
+	for _, thingDump := range pkg.Things {
+		if thingDump.Id == pkg.Area {
+			found = thingDump.Type == PlaceThing
+			break
+		}
+	}
+	if !found {
+		return nil, errors.New("the area package doesn't contain its place")
+	}
+	return pkg, nil
+}
+
+// InstallArea adds the packaged area to the world, with its place in the first place like other new places, and gives it all to the player. It returns the area's new place, and a note for each exit whose target wasn't in the package. Those exits are installed without targets, to be set by hand. If any of it can't be installed, what was is destroyed again before the error is returned.
+func InstallArea(pkg *AreaPackage, player *Thing) (*Thing, []string, error) {
+	origin, err := World.ThingForId(1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Everything in the package should be inside the area, so leave out anything claiming to be a world's first place.
+	var things []*ThingDump
+	for _, thingDump := range pkg.Things {
+		if thingDump.Parent != 0 {
+			things = append(things, thingDump)
+		}
+	}
+
+	installed, err := importThings(things, origin, player, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	idMap := installed.idMap
+	fail := func(err error) (*Thing, []string, error) {
+		installed.undo()
+		return nil, nil, err
+	}
+
+	var dangling []string
+	for _, thingDump := range installed.imported {
+		if thingDump.Type != ActionThing {
+			continue
+		}
+		table, err := DecodeTable(thingDump.Table)
+		if err != nil {
+			continue
+		}
+		var target ThingId
+		switch targetId := table["target"].(type) {
+		case ThingId:
+			target = targetId
+		case float64:
+			target = ThingId(targetId)
+		default:
+			continue
+		}
+
+		action, err := World.ThingForId(idMap[thingDump.Id])
+		if err != nil {
+			return fail(err)
+		}
+		if _, ok := idMap[target]; ok {
+			continue
+		}
+
+		dangling = append(dangling, fmt.Sprintf("The exit “%s” (#%d) led to #%d, which wasn't in the package, so it has no target now.",
+			thingDump.Name, action.Id, target))
+		if _, ok := action.Table["target"]; ok {
+			delete(action.Table, "target")
+			if err := World.SaveThing(action); err != nil {
+				return fail(fmt.Errorf("couldn't save the exit “%s” (#%d) without its target: %s", thingDump.Name, action.Id, err.Error()))
+			}
+		}
+	}
+
+	area, err := World.ThingForId(idMap[pkg.Area])
+	if err != nil {
+		return fail(err)
+	}
+	return area, dangling, nil
+}
@@ -1,16 +1,17 @@
 package mess
 
 import (
-	"github.com/bmizerany/pq"
+	"errors"
 	"github.com/jameskeane/bcrypt"
 	"log"
 	"time"
 )
 
 type AccountStore interface {
-	AccountForLogin(name, password string) (acc *Account)
-	CreateAccount(name, password string) (acc *Account)
-	GetAccount(name string) (acc *Account)
+	AccountForLogin(name, password string) (*Account, error)
+	// CreateAccount makes an account & its character, with a password hashed by HashPassword.
+	CreateAccount(name, passwordHash string) (*Account, error)
+	GetAccount(name string) (*Account, error)
 }
 
 type Account struct {
@@ -20,66 +21,84 @@ type Account struct {
 	Created      time.Time
 }
 
-func (w *DatabaseWorld) GetAccount(name string) (acc *Account) {
-	acc = &Account{}
+// HashPassword hashes the password for a new account. Hashing is slow on purpose, so it shouldn't be done with the world locked.
+func HashPassword(password string) (string, error) {
+	passwordHash, err := bcrypt.Hash(password)
+	if err != nil {
+		log.Println("Couldn't hash password to create an account:", err.Error())
+		return "", &StoreError{StoreUnavailable, err}
+	}
+	return passwordHash, nil
+}
+
+// errBadLogin is the error for both unknown login names & wrong passwords, so people can't use logging in to find out who has accounts.
+var errBadLogin = &StoreError{StoreNotFound, errors.New("there's no account with that name & password")}
+
+func (w *DatabaseWorld) GetAccount(name string) (*Account, error) {
+	acc := &Account{}
 	row := w.db.QueryRow("SELECT loginname, character, created FROM account WHERE loginname = $1",
 		name)
 	err := row.Scan(&acc.LoginName, &acc.Character, &acc.Created)
 	if err != nil {
 		log.Println("Error loading account with name", name, ":", err)
-		return nil
+		return nil, databaseError(err)
 	}
-	return
+	return acc, nil
 }
 
-func (w *DatabaseWorld) AccountForLogin(name, password string) (acc *Account) {
-	acc = &Account{}
+func (w *DatabaseWorld) AccountForLogin(name, password string) (*Account, error) {
+	acc := &Account{}
 	row := w.db.QueryRow("SELECT loginname, passwordhash, character, created FROM account WHERE loginname = $1",
 		name)
 	err := row.Scan(&acc.LoginName, &acc.PasswordHash, &acc.Character, &acc.Created)
 	// TODO: oh look there are timing attacks wheeeeeeee
 	if err != nil {
-		thing, ok := err.(pq.PGError)
-		var message string
-		if ok {
-			message = thing.Get('M')
-		} else {
-			message = err.Error()
+		log.Println("Error loading account with name", name, ":", err.Error())
+		err = databaseError(err)
+		if StoreFailureOf(err) == StoreNotFound {
+			return nil, errBadLogin
 		}
-		log.Println("Error loading account with name", name, ":", message)
-		return nil
+		return nil, err
 	}
 
 	if !bcrypt.Match(password, acc.PasswordHash) {
 		log.Println("Bad login attempt for account", name)
-		return nil
+		return nil, errBadLogin
 	}
 
-	return
+	return acc, nil
 }
 
-func (w *DatabaseWorld) CreateAccount(name, password string) (acc *Account) {
-	passwordHash, err := bcrypt.Hash(password)
-	if err != nil {
-		log.Println("Couldn't hash password to create an account:", err.Error())
-		return nil
+func (w *DatabaseWorld) CreateAccount(name, passwordHash string) (*Account, error) {
+	// Check for the name first, so we don't make a character we won't use.
+	_, err := w.GetAccount(name)
+	if err == nil {
+		return nil, &StoreError{StoreDuplicateLogin, errors.New("an account with that name already exists")}
+	}
+	if StoreFailureOf(err) != StoreNotFound {
+		return nil, err
 	}
 
 	// TODO: Config setting for where to start new players?
-	origin := World.ThingForId(1)
-	char := World.CreateThing(name, PlayerThing, nil, origin)
-	if char == nil {
-		log.Println("Couldn't create character to create an account")
-		return nil
+	origin, err := World.ThingForId(1)
+	if err != nil {
+		log.Println("Couldn't find starting place to create an account:", err.Error())
+		return nil, err
+	}
+	char, err := World.CreateThing(name, PlayerThing, nil, origin)
+	if err != nil {
+		log.Println("Couldn't create character to create an account:", err.Error())
+		return nil, err
 	}
 
 	tx, err := w.db.Begin()
 	if err != nil {
 		log.Println("Couldn't open transaction to create an account:", err.Error())
-		return nil
+		World.DestroyThing(char)
+		return nil, databaseError(err)
 	}
 
-	acc = &Account{name, passwordHash, char.Id, time.Unix(0, 0)}
+	acc := &Account{name, passwordHash, char.Id, time.Unix(0, 0)}
 
 	row := tx.QueryRow("INSERT INTO account (loginname, passwordhash, character) VALUES ($1, $2, $3) RETURNING created",
 		name, passwordHash, acc.Character)
@@ -87,15 +106,77 @@ func (w *DatabaseWorld) CreateAccount(name, password string) (acc *Account) {
 	if err != nil {
 		log.Println("Couldn't create new account:", err.Error())
 		tx.Rollback()
-		return nil
+		World.DestroyThing(char)
+		if isUniqueViolation(err) {
+			return nil, &StoreError{StoreDuplicateLogin, err}
+		}
+		return nil, databaseError(err)
 	}
 
 	err = tx.Commit()
 	if err != nil {
 		log.Println("Couldn't commit transaction to create new account:", err.Error())
 		tx.Rollback()
-		return nil
+		World.DestroyThing(char)
+		return nil, databaseError(err)
+	}
+
+	return acc, nil
+}
+
+func (w *DatabaseWorld) AllAccounts() ([]*Account, error) {
+	rows, err := w.db.Query("SELECT loginname, character, created FROM account ORDER BY loginname")
+	if err != nil {
+		log.Println("Error listing accounts:", err.Error())
+		return nil, databaseError(err)
+	}
+	defer rows.Close()
+
+	var accounts []*Account
+	for rows.Next() {
+		acc := &Account{}
+		err = rows.Scan(&acc.LoginName, &acc.Character, &acc.Created)
+		if err != nil {
+			log.Println("Error listing accounts:", err.Error())
+			return nil, databaseError(err)
+		}
+		accounts = append(accounts, acc)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Println("Error listing accounts:", err.Error())
+		return nil, databaseError(err)
 	}
+	return accounts, nil
+}
 
-	return
+func (w *DatabaseWorld) ImportAccount(name, password string, character ThingId) (*Account, error) {
+	passwordHash, err := bcrypt.Hash(password)
+	if err != nil {
+		log.Println("Couldn't hash password to import an account:", err.Error())
+		return nil, &StoreError{StoreUnavailable, err}
+	}
+
+	acc := &Account{name, passwordHash, character, time.Unix(0, 0)}
+	row := w.db.QueryRow("INSERT INTO account (loginname, passwordhash, character) VALUES ($1, $2, $3) RETURNING created",
+		name, passwordHash, character)
+	err = row.Scan(&acc.Created)
+	if err != nil {
+		log.Println("Couldn't import account", name, ":", err.Error())
+		if isUniqueViolation(err) {
+			return nil, &StoreError{StoreDuplicateLogin, err}
+		}
+		return nil, databaseError(err)
+	}
+	return acc, nil
+}
+
+// DeleteAccount removes the named account, leaving its character. It's for undoing an import that failed.
+func (w *DatabaseWorld) DeleteAccount(name string) error {
+	_, err := w.db.Exec("DELETE FROM account WHERE loginname = $1", name)
+	if err != nil {
+		log.Println("Couldn't delete account", name, ":", err.Error())
+		return databaseError(err)
+	}
+	return nil
 }
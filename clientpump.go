@@ -9,9 +9,10 @@ import (
 )
 
 type ClientPump struct {
-	ToServer chan string
-	writer   *bufio.Writer
-	conn     net.Conn
+	ToServer  chan string
+	writer    *bufio.Writer
+	conn      net.Conn
+	writeLock sync.Mutex
 }
 
 var clientLock sync.Mutex
@@ -21,7 +22,7 @@ func NewClientPump(conn net.Conn) *ClientPump {
 	clientLock.Lock()
 	defer clientLock.Unlock()
 
-	client := &ClientPump{make(chan string), bufio.NewWriter(conn), conn}
+	client := &ClientPump{ToServer: make(chan string), writer: bufio.NewWriter(conn), conn: conn}
 	clients[conn] = client
 
 	// Start the client service.
@@ -79,6 +80,8 @@ func (client *ClientPump) Read() {
 func (client *ClientPump) Send(text string) {
 	log.Println("Sending", text, "to", client)
 
+	// Many players' commands can send to the same client at once, so only write one line at a time.
+	client.writeLock.Lock()
 	_, err := client.writer.WriteString(text)
 	if err == nil {
 		err = client.writer.WriteByte('\n')
@@ -86,6 +89,7 @@ func (client *ClientPump) Send(text string) {
 			err = client.writer.Flush()
 		}
 	}
+	client.writeLock.Unlock()
 	if err != nil {
 		log.Println("Sending text to", client, "failed:", err)
 		client.Close()
@@ -5,26 +5,51 @@ import (
 	"encoding/json"
 	"flag"
 	_ "github.com/bmizerany/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/natmeox/mess"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-func installDatabase() {
-	data, err := Asset("mess.sql")
+func openDatabase() *sql.DB {
+	if mess.Config.Driver == "memory" {
+		log.Println("The memory driver keeps the world in memory only, so there's no database to set up. Run `mess` to start the server.")
+		return nil
+	}
+
+	driver := mess.Config.Driver
+	if driver == "" {
+		driver = "postgres"
+	}
+
+	db, err := sql.Open(driver, mess.Config.Dsn)
 	if err != nil {
-		log.Println("Error finding SQL:", err)
+		log.Println("Error opening database:", err)
+		return nil
+	}
+	return db
+}
+
+func installDatabase() {
+	db := openDatabase()
+	if db == nil {
 		return
 	}
+	defer db.Close()
 
-	db, err := sql.Open("postgres", mess.Config.Dsn)
+	version, err := mess.SchemaVersion(db)
 	if err != nil {
-		log.Println("Error opening database:", err)
+		log.Println("Error checking database:", err)
+		return
+	}
+	if version > 0 {
+		log.Println("There's already a mess in that database. Run `mess --migrate` to update it instead.")
 		return
 	}
 
-	_, err = db.Exec(string(data))
+	err = mess.Migrate(db, mess.Migrations)
 	if err != nil {
 		log.Println("Error executing SQL:", err)
 		return
@@ -33,10 +58,101 @@ func installDatabase() {
 	log.Println("Yay! The database was created. Now run `mess` to start the server.")
 }
 
+func migrateDatabase() {
+	db := openDatabase()
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	err := mess.Migrate(db, mess.Migrations)
+	if err != nil {
+		log.Println("Error migrating database:", err)
+		return
+	}
+
+	log.Println("The database is up to date. Now run `mess` to start the server.")
+}
+
+// openWorld opens the configured database for the mess package to use directly, without the server's cache in front of it.
+func openWorld() bool {
+	if mess.Config.Driver == "memory" {
+		log.Println("The memory driver keeps the world in memory only, so there's no world to export or import into.")
+		return false
+	}
+
+	worldStore, accountStore, err := mess.OpenStores()
+	if err != nil {
+		log.Println("Error opening database:", err)
+		return false
+	}
+	mess.World = worldStore
+	mess.Accounts = accountStore
+	return true
+}
+
+func exportWorld(path string) {
+	if !openWorld() {
+		return
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Println("Error opening export file", path, ":", err)
+		return
+	}
+
+	err = mess.ExportWorld(file)
+	if err != nil {
+		log.Println("Error exporting world:", err)
+		file.Close()
+		return
+	}
+	err = file.Close()
+	if err != nil {
+		log.Println("Error writing export file", path, ":", err)
+		return
+	}
+
+	log.Println("Exported the world to", path)
+}
+
+func importWorld(path, passwordsPath string) {
+	if !openWorld() {
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Println("Error opening import file", path, ":", err)
+		return
+	}
+	defer file.Close()
+
+	// The imported accounts' new passwords go to stdout or the named file, not the log.
+	passwords := os.Stdout
+	if passwordsPath != "" {
+		passwords, err = os.OpenFile(passwordsPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			log.Println("Error creating passwords file", passwordsPath, ":", err)
+			return
+		}
+		defer passwords.Close()
+	}
+
+	err = mess.ImportWorld(file, passwords)
+	if err != nil {
+		log.Println("Error importing world:", err)
+		return
+	}
+
+	log.Println("Imported the world from", path)
+}
+
 func installSite() {
 	for _, assetname := range AssetNames() {
-		// Don't write out mess.sql, that's for installNewDatabase() to use.
-		if assetname == "mess.sql" {
+		// Don't write out the migrations, those are for installDatabase() & migrateDatabase() to use.
+		if strings.HasPrefix(assetname, "migrations/") {
 			continue
 		}
 
@@ -82,9 +198,17 @@ func main() {
 	var configPath string
 	var newSite bool
 	var newDatabase bool
+	var migrate bool
+	var exportPath string
+	var importPath string
+	var passwordsPath string
 	flag.StringVar(&configPath, "config", "./config.json", "path to configuration file")
 	flag.BoolVar(&newSite, "new-site", false, "install a new site & exit")
 	flag.BoolVar(&newDatabase, "new-database", false, "install a new database & exit")
+	flag.BoolVar(&migrate, "migrate", false, "update the database to the latest schema & exit")
+	flag.StringVar(&exportPath, "export", "", "export the whole world to the named JSON file & exit")
+	flag.StringVar(&importPath, "import", "", "import the things & accounts in the named JSON file into the world & exit")
+	flag.StringVar(&passwordsPath, "passwords", "", "with --import, write the imported accounts' new passwords to the named new file instead of stdout")
 
 	flag.Parse()
 
@@ -110,10 +234,28 @@ func main() {
 		return
 	}
 
+	mess.Migrations, err = mess.LoadMigrations(mess.Config.Driver, AssetNames(), Asset)
+	if err != nil {
+		log.Println("Error loading database migrations:", err)
+		return
+	}
+
 	if newDatabase {
 		installDatabase()
 		return
 	}
+	if migrate {
+		migrateDatabase()
+		return
+	}
+	if exportPath != "" {
+		exportWorld(exportPath)
+		return
+	}
+	if importPath != "" {
+		importWorld(importPath, passwordsPath)
+		return
+	}
 
 	mess.Server()
 }
@@ -0,0 +1,419 @@
+package mess
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+)
+
+// DumpVersion is the version of the export format written by ExportWorld. ImportWorld refuses dumps from newer versions.
+const DumpVersion = 1
+
+// WorldDump is a whole world as exported to a file: all its things, and its accounts without their passwords.
+type WorldDump struct {
+	Version  int            `json:"version"`
+	Things   []*ThingDump   `json:"things"`
+	Accounts []*AccountDump `json:"accounts,omitempty"`
+}
+
+// ThingDump is a thing as exported to a file. Its ids are the ones it had in the exported world, so they're remapped when it's imported.
+type ThingDump struct {
+	Id        ThingId     `json:"id"`
+	Type      ThingType   `json:"type"`
+	Name      string      `json:"name"`
+	Parent    ThingId     `json:"parent,omitempty"`
+	Owner     ThingId     `json:"owner,omitempty"`
+	Superuser bool        `json:"superuser,omitempty"`
+	Wizard    bool        `json:"wizard,omitempty"`
+	AdminList ThingIdList `json:"admins,omitempty"`
+	AllowList ThingIdList `json:"allowed,omitempty"`
+	DenyList  ThingIdList `json:"denied,omitempty"`
+	// Table is the thing's table data, with its references to things written as {"$thing": id}.
+	Table   json.RawMessage `json:"table"`
+	Program string          `json:"program,omitempty"`
+}
+
+// AccountDump is an account as exported to a file. Passwords aren't exported, so imported accounts get new ones.
+type AccountDump struct {
+	LoginName string  `json:"login"`
+	Character ThingId `json:"character"`
+}
+
+// ThingLister is a WorldStore that can list all the things in it, so the world can be exported.
+type ThingLister interface {
+	AllThingIds() ([]ThingId, error)
+}
+
+// AccountLister is an AccountStore that can list all its accounts (without their password hashes), so they can be exported.
+type AccountLister interface {
+	AllAccounts() ([]*Account, error)
+}
+
+// AccountImporter is an AccountStore that can make an account for a character that already exists, so accounts can be imported, and delete it again if the rest of the import fails.
+type AccountImporter interface {
+	ImportAccount(name, password string, character ThingId) (*Account, error)
+	DeleteAccount(name string) error
+}
+
+// SuperuserSetter is a WorldStore that can make things superusers, so superusers can be imported. Saving a thing never changes whether it's a superuser.
+type SuperuserSetter interface {
+	SetSuperuser(id ThingId, superuser bool) error
+}
+
+// ExportWorld writes every thing & account in the world to w as indented JSON, in order by id & login name, so dumps of similar worlds can be compared with diff.
+func ExportWorld(w io.Writer) error {
+	lister, ok := World.(ThingLister)
+	if !ok {
+		return errors.New("this world can't list its things to export them")
+	}
+	ids, err := lister.AllThingIds()
+	if err != nil {
+		return err
+	}
+	sort.Sort(thingIdsById(ids))
+
+	dump := &WorldDump{Version: DumpVersion}
+	for _, id := range ids {
+		thing, err := World.ThingForId(id)
+		if err != nil {
+			return err
+		}
+		thingDump, err := dumpThing(thing)
+		if err != nil {
+			return fmt.Errorf("couldn't export thing #%d: %s", id, err.Error())
+		}
+		dump.Things = append(dump.Things, thingDump)
+	}
+
+	if accLister, ok := Accounts.(AccountLister); ok {
+		accounts, err := accLister.AllAccounts()
+		if err != nil {
+			return err
+		}
+		for _, acc := range accounts {
+			dump.Accounts = append(dump.Accounts, &AccountDump{acc.LoginName, acc.Character})
+		}
+		sort.Sort(accountDumpsByName(dump.Accounts))
+	}
+
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+func dumpThing(thing *Thing) (*ThingDump, error) {
+	table, err := EncodeTable(thing.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	thingDump := &ThingDump{
+		Id:        thing.Id,
+		Type:      thing.Type,
+		Name:      thing.Name,
+		Parent:    thing.Parent,
+		Owner:     thing.Owner,
+		Superuser: thing.Superuser,
+		Wizard:    thing.Wizard,
+		AdminList: thing.AdminList,
+		AllowList: thing.AllowList,
+		DenyList:  thing.DenyList,
+		Table:     table,
+	}
+	if thing.Program != nil {
+		thingDump.Program = thing.Program.Text
+	}
+	return thingDump, nil
+}
+
+type accountDumpsByName []*AccountDump
+
+func (l accountDumpsByName) Len() int           { return len(l) }
+func (l accountDumpsByName) Less(i, j int) bool { return l[i].LoginName < l[j].LoginName }
+func (l accountDumpsByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// ImportWorld reads a dump written by ExportWorld and adds its things & accounts to the world, giving them new ids. If the world is still empty (only its first place), the dump's first place is merged into it; otherwise the dump's places without parents are made new places in the first place. Things whose parents weren't in the dump are put in the first place too. References to things that weren't in the dump are dropped. Imported accounts get new random passwords, which are written to passwords, one "login<tab>password" line each; accounts whose names are already taken are skipped.
+//
+// The stores can't import in one transaction, so if the import fails partway, ImportWorld deletes the accounts & destroys the things it made, and puts back the first place if it merged into it, before returning the error.
+func ImportWorld(r io.Reader, passwords io.Writer) error {
+	var dump WorldDump
+	err := json.NewDecoder(r).Decode(&dump)
+	if err != nil {
+		return fmt.Errorf("couldn't read the world dump: %s", err.Error())
+	}
+	if dump.Version > DumpVersion {
+		return fmt.Errorf("the world dump is version %d, but this mess can only import up to version %d", dump.Version, DumpVersion)
+	}
+
+	// Check everything that can be checked before making anything, so fewer imports have to be undone.
+	for _, thingDump := range dump.Things {
+		if _, err := DecodeTable(thingDump.Table); err != nil {
+			return fmt.Errorf("couldn't read the table of thing #%d: %s", thingDump.Id, err.Error())
+		}
+		if _, ok := World.(SuperuserSetter); thingDump.Superuser && !ok {
+			return errors.New("this world can't import superusers")
+		}
+	}
+	importer, ok := Accounts.(AccountImporter)
+	if len(dump.Accounts) > 0 && !ok {
+		return errors.New("this world can't import accounts")
+	}
+
+	origin, err := World.ThingForId(1)
+	if err != nil {
+		return err
+	}
+	empty := false
+	if lister, ok := World.(ThingLister); ok {
+		ids, err := lister.AllThingIds()
+		if err != nil {
+			return err
+		}
+		empty = len(ids) == 1
+	}
+
+	things, err := importThings(dump.Things, origin, nil, empty)
+	if err != nil {
+		return err
+	}
+	log.Println("Imported", len(things.imported), "things")
+
+	var accounts []string
+	undo := func() {
+		for _, name := range accounts {
+			if err := importer.DeleteAccount(name); err != nil {
+				log.Println("Couldn't delete imported account", name, "after the import failed:", err.Error())
+			}
+		}
+		things.undo()
+	}
+	for _, accDump := range dump.Accounts {
+		charId, ok := things.idMap[accDump.Character]
+		if !ok {
+			log.Println("Not importing account", accDump.LoginName, "as its character #", accDump.Character, "wasn't in the dump")
+			continue
+		}
+		password, err := randomPassword()
+		if err != nil {
+			undo()
+			return err
+		}
+		_, err = importer.ImportAccount(accDump.LoginName, password, charId)
+		if StoreFailureOf(err) == StoreDuplicateLogin {
+			log.Println("Not importing account", accDump.LoginName, "as there's already an account with that name")
+			continue
+		} else if err != nil {
+			undo()
+			return fmt.Errorf("couldn't import account %s: %s", accDump.LoginName, err.Error())
+		}
+		accounts = append(accounts, accDump.LoginName)
+
+		_, err = fmt.Fprintf(passwords, "%s\t%s\n", accDump.LoginName, password)
+		if err != nil {
+			undo()
+			return fmt.Errorf("couldn't write the password for account %s: %s", accDump.LoginName, err.Error())
+		}
+		log.Println("Imported account", accDump.LoginName)
+	}
+	return nil
+}
+
+// thingImport is what importThings did, so it can be undone.
+type thingImport struct {
+	// idMap is the new ids of the dumped things by their old ones.
+	idMap map[ThingId]ThingId
+	// imported is the dumped things that were imported, parents first.
+	imported []*ThingDump
+	// created is the things made for them, in the order they were made.
+	created []*Thing
+	// home is the place a dumped place was merged into, as it was before, or nil if none was.
+	home *Thing
+}
+
+// undo destroys the things the import made, children first, and puts back the place it merged into. Failures are logged, as there's nothing else to do about them.
+func (ti *thingImport) undo() {
+	for i := len(ti.created) - 1; i >= 0; i-- {
+		thing := ti.created[i]
+		if err := World.DestroyThing(thing); err != nil {
+			log.Println("Couldn't destroy imported thing", thing.Id, "after the import failed:", err.Error())
+		}
+	}
+	if ti.home != nil {
+		if err := World.SaveThing(ti.home); err != nil {
+			log.Println("Couldn't put back thing", ti.home.Id, "after the import failed:", err.Error())
+		}
+	}
+	log.Println("Undid the import of", len(ti.created), "things")
+}
+
+// importThings creates the dumped things in the world, with their parents first so there's something to create each one in. If merge is set, the first parentless place is merged into home; other parentless places, and things whose parents aren't in the dump, are put in home. If owner is set, the things are made theirs, instead of belonging to whoever they did in the dump. If it fails, it undoes what it did before returning the error.
+func importThings(things []*ThingDump, home *Thing, owner *Thing, merge bool) (*thingImport, error) {
+	byId := make(map[ThingId]*ThingDump, len(things))
+	for _, thingDump := range things {
+		byId[thingDump.Id] = thingDump
+	}
+	children := make(map[ThingId][]*ThingDump)
+	var queue []*ThingDump
+	for _, thingDump := range things {
+		if _, ok := byId[thingDump.Parent]; ok && thingDump.Parent != thingDump.Id {
+			children[thingDump.Parent] = append(children[thingDump.Parent], thingDump)
+		} else {
+			queue = append(queue, thingDump)
+		}
+	}
+
+	ti := &thingImport{idMap: make(map[ThingId]ThingId, len(things))}
+	fail := func(err error) (*thingImport, error) {
+		ti.undo()
+		return nil, err
+	}
+	for len(queue) > 0 {
+		thingDump := queue[0]
+		queue = append(queue[1:], children[thingDump.Id]...)
+
+		if merge && ti.home == nil && thingDump.Parent == 0 && thingDump.Type == PlaceThing {
+			// Load home again for putting back, so restoring the dumped place into it doesn't change the copy.
+			before, err := World.ThingForId(home.Id)
+			if err != nil {
+				return fail(err)
+			}
+			if before == home {
+				if before, err = copyForSave(home); err != nil {
+					return fail(err)
+				}
+			}
+			ti.home = before
+			ti.idMap[thingDump.Id] = home.Id
+			ti.imported = append(ti.imported, thingDump)
+			continue
+		}
+
+		parent := home
+		if parentId, ok := ti.idMap[thingDump.Parent]; ok {
+			var err error
+			parent, err = World.ThingForId(parentId)
+			if err != nil {
+				return fail(err)
+			}
+		}
+		thing, err := World.CreateThing(thingDump.Name, thingDump.Type, owner, parent)
+		if err != nil {
+			return fail(fmt.Errorf("couldn't import thing #%d: %s", thingDump.Id, err.Error()))
+		}
+		ti.idMap[thingDump.Id] = thing.Id
+		ti.imported = append(ti.imported, thingDump)
+		ti.created = append(ti.created, thing)
+	}
+
+	// Now every thing has its new id, so fill them in with their (remapped) data.
+	for _, thingDump := range ti.imported {
+		thing, err := World.ThingForId(ti.idMap[thingDump.Id])
+		if err != nil {
+			return fail(err)
+		}
+		err = thingDump.restore(thing, ti.idMap, owner)
+		if err != nil {
+			return fail(fmt.Errorf("couldn't import thing #%d: %s", thingDump.Id, err.Error()))
+		}
+		err = World.SaveThing(thing)
+		if err != nil {
+			return fail(fmt.Errorf("couldn't save imported thing #%d: %s", thingDump.Id, err.Error()))
+		}
+		if thing.Superuser {
+			// SaveThing doesn't save whether a thing is a superuser, so set it separately.
+			setter, ok := World.(SuperuserSetter)
+			if !ok {
+				return fail(fmt.Errorf("couldn't import thing #%d: this world can't import superusers", thingDump.Id))
+			}
+			err = setter.SetSuperuser(thing.Id, true)
+			if err != nil {
+				return fail(fmt.Errorf("couldn't save imported thing #%d: %s", thingDump.Id, err.Error()))
+			}
+		}
+	}
+	return ti, nil
+}
+
+// restore sets thing's data to the dumped thing's, using idMap to turn the dump's ids into the world's. If owner is set, thing is given to them instead of its owner in the dump, and isn't made a superuser or wizard, nor given table keys only superusers can set unless owner is one.
+func (thingDump *ThingDump) restore(thing *Thing, idMap map[ThingId]ThingId, owner *Thing) error {
+	table, err := DecodeTable(thingDump.Table)
+	if err != nil {
+		return err
+	}
+	if target, ok := table["target"].(float64); ok && thingDump.Type == ActionThing {
+		// Older actions' targets are plain numbers (see ActionTarget), so make them references, to be remapped like the rest.
+		table["target"] = ThingId(target)
+	}
+	for _, ref := range TableThingRefs(table) {
+		if _, ok := idMap[ref]; !ok {
+			RemoveThingRefs(table, ref)
+		}
+	}
+
+	if owner != nil && !owner.Superuser {
+		keepSuperuserTableKeys(table, thing.Table)
+	}
+
+	thing.Name = thingDump.Name
+	if owner == nil {
+		thing.Owner = idMap[thingDump.Owner]
+		thing.Superuser = thingDump.Superuser
+		thing.Wizard = thingDump.Wizard
+	} else if thing.Type.HasOwner() {
+		thing.Owner = owner.Id
+	}
+	thing.AdminList = remapIdList(thingDump.AdminList, idMap)
+	thing.AllowList = remapIdList(thingDump.AllowList, idMap)
+	thing.DenyList = remapIdList(thingDump.DenyList, idMap)
+	thing.Table = remapThingRefs(table, idMap).(map[string]interface{})
+	thing.Program = nil
+	if thingDump.Program != "" {
+		thing.Program = LoadProgram(thingDump.Program)
+	}
+	return nil
+}
+
+func remapIdList(l ThingIdList, idMap map[ThingId]ThingId) ThingIdList {
+	var remapped ThingIdList
+	for _, id := range l {
+		if newId, ok := idMap[id]; ok {
+			remapped = append(remapped, newId)
+		}
+	}
+	return remapped
+}
+
+// remapThingRefs replaces the ThingIds in table data with the ones idMap maps them to. Tables & lists are changed in place.
+func remapThingRefs(value interface{}, idMap map[ThingId]ThingId) interface{} {
+	switch v := value.(type) {
+	case ThingId:
+		return idMap[v]
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = remapThingRefs(item, idMap)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = remapThingRefs(item, idMap)
+		}
+	}
+	return value
+}
+
+func randomPassword() (string, error) {
+	data := make([]byte, 9)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
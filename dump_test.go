@@ -0,0 +1,151 @@
+package mess
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// exportMemoryWorld makes a small world with a superuser account & a room in a new MemoryWorld, and returns its dump.
+func exportMemoryWorld(t *testing.T) []byte {
+	_, restore := useMemoryWorld()
+	defer restore()
+
+	origin := mustLoad(t, 1)
+	origin.Name = "Exported Room"
+	if err := World.SaveThing(origin); err != nil {
+		t.Fatal(err)
+	}
+	passwordHash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := Accounts.CreateAccount("admin", passwordHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := World.(SuperuserSetter).SetSuperuser(account.Character, true); err != nil {
+		t.Fatal(err)
+	}
+	second := mustCreate(t, "Second Room", PlaceThing, mustLoad(t, account.Character), origin)
+	// Older actions' targets are plain numbers.
+	exit := mustCreate(t, "east", ActionThing, nil, origin)
+	exit.Table["target"] = float64(second.Id)
+	if err := World.SaveThing(exit); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportWorld(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func findAccount(t *testing.T, mem *MemoryWorld, name string) *Account {
+	accounts, err := mem.AllAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, acc := range accounts {
+		if acc.LoginName == name {
+			return acc
+		}
+	}
+	return nil
+}
+
+func TestImportIntoEmptyWorld(t *testing.T) {
+	dump := exportMemoryWorld(t)
+	mem, restore := useMemoryWorld()
+	defer restore()
+
+	var passwords bytes.Buffer
+	if err := ImportWorld(bytes.NewReader(dump), &passwords); err != nil {
+		t.Fatal(err)
+	}
+
+	if origin := mustLoad(t, 1); origin.Name != "Exported Room" {
+		t.Errorf("the empty world's first place wasn't merged with the dump's, but is named %q", origin.Name)
+	}
+	acc := findAccount(t, mem, "admin")
+	if acc == nil {
+		t.Fatal("the account wasn't imported")
+	}
+	if !mustLoad(t, acc.Character).Superuser {
+		t.Error("the imported superuser isn't one")
+	}
+
+	fields := strings.Fields(passwords.String())
+	if len(fields) != 2 || fields[0] != "admin" {
+		t.Fatalf("the passwords written were %q, not one line for the account", passwords.String())
+	}
+	if _, err := mem.AccountForLogin("admin", fields[1]); err != nil {
+		t.Errorf("couldn't log in with the written password: %s", err.Error())
+	}
+}
+
+func TestImportIntoWorldWithThings(t *testing.T) {
+	dump := exportMemoryWorld(t)
+	_, restore := useMemoryWorld()
+	defer restore()
+
+	origin := mustLoad(t, 1)
+	mustCreate(t, "Box", RegularThing, nil, origin)
+
+	if err := ImportWorld(bytes.NewReader(dump), &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	origin = mustLoad(t, 1)
+	if origin.Name != "Room One" {
+		t.Errorf("the first place of a world with things in it was overwritten, and is named %q", origin.Name)
+	}
+	var names []string
+	for _, id := range origin.Contents {
+		names = append(names, mustLoad(t, id).Name)
+	}
+	if strings.Join(names, ",") != "Box,Exported Room" {
+		t.Errorf("the first place contains %v, not the box & the dump's first place", names)
+	}
+
+	var exit *Thing
+	for _, id := range mustLoad(t, origin.Contents[len(origin.Contents)-1]).Contents {
+		if thing := mustLoad(t, id); thing.Type == ActionThing {
+			exit = thing
+		}
+	}
+	if exit == nil {
+		t.Fatal("the dump's first place's exit wasn't imported")
+	}
+	if target := exit.ActionTarget(); target == nil || target.Name != "Second Room" {
+		t.Errorf("the imported exit with a numeric target leads to %v, not the second room", target)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestImportUndoneOnFailure(t *testing.T) {
+	dump := exportMemoryWorld(t)
+	mem, restore := useMemoryWorld()
+	defer restore()
+
+	if err := ImportWorld(bytes.NewReader(dump), failingWriter{}); err == nil {
+		t.Fatal("the import succeeded without writing the passwords")
+	}
+
+	if acc := findAccount(t, mem, "admin"); acc != nil {
+		t.Error("the failed import's account was kept")
+	}
+	if ids, _ := mem.AllThingIds(); !sameIds(ids, ThingIdList{1}) {
+		t.Errorf("the failed import left things %v", ids)
+	}
+	if origin := mustLoad(t, 1); origin.Name != "Room One" || len(origin.Contents) != 0 {
+		t.Errorf("the failed import left the first place named %q containing %v", origin.Name, origin.Contents)
+	}
+}
@@ -0,0 +1,103 @@
+package mess
+
+// Events are the functions a thing's program can define to hear about what happens around the thing. Each is called with its env's `me` as the thing the event is about, `here` as where it happened, and `target` as the thing whose program is hearing it.
+const (
+	// CanEnter(thing, from) is asked of a thing before another thing moves into it. Returning false keeps the thing out.
+	EventCanEnter = "CanEnter"
+	// CanLeave(thing, to) is asked of a thing before another thing moves out of it. Returning false keeps the thing in.
+	EventCanLeave = "CanLeave"
+	// Entered(thing, from) is called on a thing & its contents after another thing moves into it.
+	EventEntered = "Entered"
+	// Left(thing, to) is called on a thing & its contents after another thing moves out of it.
+	EventLeft = "Left"
+	// Said(speaker, text) is called on a place & the rest of its contents when someone in it says something.
+	EventSaid = "Said"
+	// Connected(player) & Disconnected(player) are called on a player, their place & its contents when they connect to or disconnect from the game.
+	EventConnected    = "Connected"
+	EventDisconnected = "Disconnected"
+	// Created(thing) is called on a new thing's parent after it's created.
+	EventCreated = "Created"
+	// Destroyed(thing) is called on the parent a thing was in just after it's destroyed. The thing's own program is closed with it, so it isn't told.
+	EventDestroyed = "Destroyed"
+)
+
+func eventEnv(subject *Thing, here ThingId, listener *Thing) map[string]interface{} {
+	return map[string]interface{}{
+		"me":     subject.Id,
+		"here":   here,
+		"target": listener.Id,
+	}
+}
+
+// notifyEach calls the event on each of the listeners except the subject.
+func notifyEach(listeners []*Thing, event string, subject *Thing, here ThingId, args ...interface{}) {
+	for _, listener := range listeners {
+		if listener == nil || listener.Id == subject.Id {
+			continue
+		}
+		listener.TryToCall(event, eventEnv(subject, here, listener), args...)
+	}
+}
+
+// placeAndContents is the thing & the things inside it, for notifying everything in a place of an event.
+func placeAndContents(place *Thing) []*Thing {
+	if place == nil {
+		return nil
+	}
+	return append([]*Thing{place}, place.GetContents()...)
+}
+
+// AskToMove asks the programs of the thing's current parent & target whether it can move from one to the other, returning a *MoveError if either refuses.
+func (thing *Thing) AskToMove(target *Thing) error {
+	from := GetThing(thing.Parent)
+	var fromId interface{}
+	if from != nil {
+		fromId = from.Id
+		if !from.TryToAsk(EventCanLeave, eventEnv(thing, from.Id, from), thing.Id, target.Id) {
+			return &MoveError{thing, target, MoveLeaveRefused}
+		}
+	}
+	if !target.TryToAsk(EventCanEnter, eventEnv(thing, target.Id, target), thing.Id, fromId) {
+		return &MoveError{thing, target, MoveEnterRefused}
+	}
+	return nil
+}
+
+// NotifyMoved tells the place the thing moved from & everything in it that it left, then the place it moved to & everything in it that it entered.
+func NotifyMoved(thing, from, to *Thing) {
+	var fromId interface{}
+	if from != nil {
+		fromId = from.Id
+		notifyEach(placeAndContents(from), EventLeft, thing, from.Id, thing.Id, to.Id)
+	}
+	notifyEach(placeAndContents(to), EventEntered, thing, to.Id, thing.Id, fromId)
+}
+
+// NotifySaid tells the speaker's place & everything else in it what they said.
+func NotifySaid(speaker *Thing, text string) {
+	notifyEach(placeAndContents(GetThing(speaker.Parent)), EventSaid, speaker, speaker.Parent, speaker.Id, text)
+}
+
+// NotifyConnected tells the player, their place & everything in it that they connected (or disconnected, if connected is false).
+func NotifyConnected(player *Thing, connected bool) {
+	event := EventConnected
+	if !connected {
+		event = EventDisconnected
+	}
+	player.TryToCall(event, eventEnv(player, player.Parent, player), player.Id)
+	notifyEach(placeAndContents(GetThing(player.Parent)), event, player, player.Parent, player.Id)
+}
+
+// NotifyCreated tells the new thing's parent it was created.
+func NotifyCreated(thing *Thing) {
+	if parent := GetThing(thing.Parent); parent != nil {
+		parent.TryToCall(EventCreated, eventEnv(thing, parent.Id, parent), thing.Id)
+	}
+}
+
+// NotifyDestroyed tells the parent the thing was in that it's been destroyed.
+func NotifyDestroyed(thing *Thing) {
+	if parent := GetThing(thing.Parent); parent != nil {
+		parent.TryToCall(EventDestroyed, eventEnv(thing, parent.Id, parent), thing.Id)
+	}
+}
@@ -3,6 +3,7 @@ package mess
 import (
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -56,13 +57,35 @@ func (tt ThingType) HasActions() bool {
 }
 
 func (tl *ThingIdList) Things() []*Thing {
-	things := make([]*Thing, len(*tl))
-	for i := 0; i < len(*tl); i++ {
-		things[i] = World.ThingForId((*tl)[i])
+	things := make([]*Thing, 0, len(*tl))
+	for _, id := range *tl {
+		if thing := GetThing(id); thing != nil {
+			things = append(things, thing)
+		}
 	}
 	return things
 }
 
+// Without returns a copy of the list with any mentions of thingId taken out.
+func (tl ThingIdList) Without(thingId ThingId) ThingIdList {
+	ret := make(ThingIdList, 0, len(tl))
+	for _, id := range tl {
+		if id != thingId {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+func (tl ThingIdList) Contains(thingId ThingId) bool {
+	for _, id := range tl {
+		if id == thingId {
+			return true
+		}
+	}
+	return false
+}
+
 type Thing struct {
 	Id      ThingId
 	Type    ThingType
@@ -73,6 +96,8 @@ type Thing struct {
 
 	Owner     ThingId
 	Superuser bool
+	// Wizard is whether the thing's program can do anything, not only what its player could. Only superusers can make things wizards.
+	Wizard    bool
 	AdminList ThingIdList
 	AllowList ThingIdList
 	DenyList  ThingIdList
@@ -97,11 +122,19 @@ func (thing *Thing) GetURL() string {
 }
 
 func (thing *Thing) GetParent() *Thing {
-	return World.ThingForId(thing.Parent)
+	return GetThing(thing.Parent)
 }
 
 func (thing *Thing) GetOwner() *Thing {
-	return World.ThingForId(thing.Owner)
+	return GetThing(thing.Owner)
+}
+
+// PlayerId is the player the thing acts for: the thing itself if it's a player, or else its owner.
+func (thing *Thing) PlayerId() ThingId {
+	if thing.Type == PlayerThing {
+		return thing.Id
+	}
+	return thing.Owner
 }
 
 func (thing *Thing) OwnedById(playerId ThingId) bool {
@@ -112,8 +145,8 @@ func (thing *Thing) OwnedById(playerId ThingId) bool {
 }
 
 func (thing *Thing) EditableById(playerId ThingId) bool {
-	editor := World.ThingForId(playerId)
-	if editor.Superuser {
+	editor := GetThing(playerId)
+	if editor != nil && editor.Superuser {
 		return true
 	}
 
@@ -132,6 +165,23 @@ func (thing *Thing) EditableById(playerId ThingId) bool {
 	return false
 }
 
+// DestroyableById reports whether the player can recycle the thing. Only owners (and superusers) can recycle things, not admins.
+func (thing *Thing) DestroyableById(playerId ThingId) bool {
+	if thing.OwnedById(playerId) {
+		return true
+	}
+	player := GetThing(playerId)
+	return player != nil && player.Superuser
+}
+
+// RecycleHome is where the contents of the thing go if it's recycled: wherever the thing is, or the first place if it's nowhere.
+func (thing *Thing) RecycleHome() ThingId {
+	if thing.Parent != 0 {
+		return thing.Parent
+	}
+	return 1
+}
+
 func (thing *Thing) DeniedById(playerId ThingId) bool {
 	for _, deniedId := range thing.DenyList {
 		if deniedId == playerId {
@@ -141,13 +191,27 @@ func (thing *Thing) DeniedById(playerId ThingId) bool {
 	return false
 }
 
+// AllowedById reports whether the player can use the thing: use the action, run the program, enter the place, etc. If the thing has an allow list, only players on it can use the thing; otherwise anyone not on the deny list can. The thing's owner & admins can always use it.
+func (thing *Thing) AllowedById(playerId ThingId) bool {
+	if thing.EditableById(playerId) {
+		return true
+	}
+	if thing.DeniedById(playerId) {
+		return false
+	}
+	if len(thing.AllowList) > 0 {
+		return thing.AllowList.Contains(playerId)
+	}
+	return true
+}
+
 func (thing *Thing) GetContents() (contents []*Thing) {
 	if !thing.Type.HasContents() {
 		return
 	}
 	for _, thingId := range thing.Contents {
-		content := World.ThingForId(thingId)
-		if content.Type != ActionThing {
+		content := GetThing(thingId)
+		if content != nil && content.Type != ActionThing {
 			contents = append(contents, content)
 		}
 	}
@@ -159,14 +223,70 @@ func (thing *Thing) GetActions() (actions []*Thing) {
 		return
 	}
 	for _, thingId := range thing.Contents {
-		action := World.ThingForId(thingId)
-		if action.Type == ActionThing {
+		action := GetThing(thingId)
+		if action != nil && action.Type == ActionThing {
 			actions = append(actions, action)
 		}
 	}
 	return
 }
 
+// PrototypeKey is the table key for the thing a thing inherits the rest of its table data from.
+const PrototypeKey = "prototype"
+
+// Prototype is the thing this thing inherits table data from, if it has one.
+func (thing *Thing) Prototype() *Thing {
+	if protoId, ok := thing.Table[PrototypeKey].(ThingId); ok && protoId != thing.Id {
+		return GetThing(protoId)
+	}
+	return nil
+}
+
+// prototypeChain is the thing followed by its prototype, its prototype's prototype, and so on. A prototype that's already in the chain ends it, so the chain can't go round in circles.
+func (thing *Thing) prototypeChain() (chain []*Thing) {
+	seen := make(map[ThingId]bool)
+	for proto := thing; proto != nil && !seen[proto.Id]; proto = proto.Prototype() {
+		seen[proto.Id] = true
+		chain = append(chain, proto)
+	}
+	return
+}
+
+// Lookup finds the value for key in the thing's table data, or if it has none, in its prototype's, and so on along the prototype chain. It returns the value and the thing it came from, or nil & nil if no thing in the chain has a value for key.
+func (thing *Thing) Lookup(key string) (interface{}, *Thing) {
+	for _, proto := range thing.prototypeChain() {
+		if value, ok := proto.Table[key]; ok {
+			return value, proto
+		}
+	}
+	return nil, nil
+}
+
+// Inherits is the value the thing would get for key from its prototype chain, if it had none of its own.
+func (thing *Thing) Inherits(key string) interface{} {
+	if proto := thing.Prototype(); proto != nil {
+		value, _ := proto.Lookup(key)
+		return value
+	}
+	return nil
+}
+
+// Inherited finds the keys the thing gets from its prototype chain instead of its own table, and the things they come from.
+func (thing *Thing) Inherited() map[string]*Thing {
+	inherited := make(map[string]*Thing)
+	for _, proto := range thing.prototypeChain()[1:] {
+		for key := range proto.Table {
+			if _, ok := thing.Table[key]; ok || key == PrototypeKey {
+				continue
+			}
+			if _, ok := inherited[key]; !ok {
+				inherited[key] = proto
+			}
+		}
+	}
+	return inherited
+}
+
 func (thing *Thing) ActionMatches(command string) bool {
 	if thing.Type != ActionThing {
 		return false
@@ -178,7 +298,7 @@ func (thing *Thing) ActionMatches(command string) bool {
 	}
 
 	// Check our aliases.
-	if aliases, ok := thing.Table["aliases"]; ok {
+	if aliases, from := thing.Lookup("aliases"); from != nil {
 		if aliasesList, ok := aliases.([]interface{}); ok {
 			for _, alias := range aliasesList {
 				if aliasStr, ok := alias.(string); ok {
@@ -199,13 +319,12 @@ func (thing *Thing) ActionMatches(command string) bool {
 }
 
 func (thing *Thing) ActionTarget() (target *Thing) {
-	if targetId, ok := thing.Table["target"]; ok {
-		// JSON numbers are float64s. :|
-		// TODO: shouldn't these be links? how can we have thing references in our json data
-		if targetIdNum, ok := targetId.(float64); ok {
-			targetIdInt := ThingId(targetIdNum)
-			target = World.ThingForId(targetIdInt)
-		}
+	switch targetId := thing.Table["target"].(type) {
+	case ThingId:
+		target = GetThing(targetId)
+	case float64:
+		// Older actions' targets are plain JSON numbers, from before tables could refer to things.
+		target = GetThing(ThingId(targetId))
 	}
 	return
 }
@@ -223,7 +342,7 @@ func (thing *Thing) Pronouns() map[string]string {
 	ret := make(map[string]string)
 	var pronounCode string
 
-	pronounSetting := thing.Table["pronouns"]
+	pronounSetting, _ := thing.Lookup("pronouns")
 	switch pronset := pronounSetting.(type) {
 	case map[string]interface{}:
 		// pronset is already the values we should use. Copy out the known pronoun codes.
@@ -255,21 +374,128 @@ func (thing *Thing) Pronouns() map[string]string {
 	return ret
 }
 
-func (thing *Thing) MoveTo(target *Thing) bool {
-	if target.Type.HasContents() {
-		return false
+// MoveRefusal is a reason a thing can't be moved somewhere.
+type MoveRefusal int
+
+const (
+	MoveNowhere MoveRefusal = iota
+	MoveIntoSelf
+	MoveIntoContents
+	MoveIntoNonContainer
+	MoveNotControlled
+	MoveTargetNotControlled
+	MoveNotAllowed
+	MoveEnterRefused
+	MoveLeaveRefused
+)
+
+// MoveError is the error when a thing can't be moved into a target.
+type MoveError struct {
+	Thing  *Thing
+	Target *Thing
+	Reason MoveRefusal
+}
+
+func (err *MoveError) Error() string {
+	switch err.Reason {
+	case MoveNowhere:
+		return fmt.Sprintf("There's nowhere to move %s to.", err.Thing.Name)
+	case MoveIntoSelf:
+		return fmt.Sprintf("%s can't be put inside itself.", err.Thing.Name)
+	case MoveIntoContents:
+		return fmt.Sprintf("%s can't be put inside %s, which is inside it.", err.Thing.Name, err.Target.Name)
+	case MoveIntoNonContainer:
+		return fmt.Sprintf("%s can't hold other things.", err.Target.Name)
+	case MoveNotControlled:
+		return fmt.Sprintf("You don't control %s.", err.Thing.Name)
+	case MoveTargetNotControlled:
+		return fmt.Sprintf("You don't control %s.", err.Target.Name)
+	case MoveNotAllowed:
+		return fmt.Sprintf("%s isn't allowed into %s.", err.Thing.Name, err.Target.Name)
+	case MoveEnterRefused:
+		return fmt.Sprintf("%s won't let %s in.", err.Target.Name, err.Thing.Name)
+	case MoveLeaveRefused:
+		return fmt.Sprintf("%s can't leave where it is right now.", err.Thing.Name)
 	}
-	return World.MoveThing(thing, target)
+	return fmt.Sprintf("%s couldn't be moved to %s.", err.Thing.Name, err.Target.Name)
+}
+
+// CheckMoveTo returns a *MoveError if the thing can't be moved into target at all, such as if target can't hold things, is inside the thing, or is a place that doesn't allow the player in.
+func (thing *Thing) CheckMoveTo(target *Thing) error {
+	if target == nil {
+		return &MoveError{thing, target, MoveNowhere}
+	}
+	if target.Id == thing.Id {
+		return &MoveError{thing, target, MoveIntoSelf}
+	}
+	if !target.Type.HasContents() {
+		return &MoveError{thing, target, MoveIntoNonContainer}
+	}
+	if thing.Type == PlayerThing && target.Type == PlaceThing && !target.AllowedById(thing.Id) {
+		return &MoveError{thing, target, MoveNotAllowed}
+	}
+
+	// Walk out from target to make sure thing doesn't contain it.
+	seen := make(map[ThingId]bool)
+	for outerId := target.Parent; outerId != 0 && !seen[outerId]; {
+		if outerId == thing.Id {
+			return &MoveError{thing, target, MoveIntoContents}
+		}
+		seen[outerId] = true
+
+		outer := GetThing(outerId)
+		if outer == nil {
+			break
+		}
+		outerId = outer.Parent
+	}
+
+	return nil
+}
+
+// MoveTo moves the thing into target, returning a *MoveError if it can't (including if a program refuses it) or a *StoreError if the move couldn't be saved. Once it's moved, the places it left & entered are notified.
+func (thing *Thing) MoveTo(target *Thing) error {
+	err := thing.CheckMoveTo(target)
+	if err != nil {
+		return err
+	}
+	err = thing.AskToMove(target)
+	if err != nil {
+		return err
+	}
+
+	from := GetThing(thing.Parent)
+	err = World.MoveThing(thing, target)
+	if err != nil {
+		return err
+	}
+	NotifyMoved(thing, from, target)
+	return nil
+}
+
+// MoveToBy moves the thing into target on behalf of the given player, who must control both the thing and target.
+func (thing *Thing) MoveToBy(moverId ThingId, target *Thing) error {
+	if !thing.EditableById(moverId) {
+		return &MoveError{thing, target, MoveNotControlled}
+	}
+	if target != nil && !target.EditableById(moverId) {
+		return &MoveError{thing, target, MoveTargetNotControlled}
+	}
+	return thing.MoveTo(target)
 }
 
 func (thing *Thing) FindNear(name string) *Thing {
 	nameLower := strings.ToLower(name)
-	location := World.ThingForId(thing.Parent)
-
-	sets := [][]ThingId{thing.Contents, []ThingId{location.Id}, location.Contents}
+	sets := [][]ThingId{thing.Contents}
+	if location := GetThing(thing.Parent); location != nil {
+		sets = append(sets, []ThingId{location.Id}, location.Contents)
+	}
 	for _, set := range sets {
 		for _, otherId := range set {
-			otherThing := World.ThingForId(otherId)
+			otherThing := GetThing(otherId)
+			if otherThing == nil {
+				continue
+			}
 			otherNameLower := strings.ToLower(otherThing.Name)
 			if strings.HasPrefix(otherNameLower, nameLower) {
 				return otherThing
@@ -293,46 +519,250 @@ func (thing *Thing) FindInside(name string) *Thing {
 	return nil
 }
 
+// ProgramLimitsKey is the table key for a program's own limits, as a table of "instructions", "memoryKB" & "timeoutMillis". Programs can set lower limits than the mess's, but only superusers' programs can have higher ones.
+const ProgramLimitsKey = "limits"
+
+// ProgramRunAsKey is the table key for whose authority a program acts with: "owner" (the default) for its owner's, or "caller" to also be limited to that of the player who explicitly called it, as by using an action. Only superusers can set it (see MayChangeTableKey).
+const ProgramRunAsKey = "runAs"
+
+// superuserTableKeys are the table keys only superusers can set or remove, as they change whose authority a thing's program acts with.
+var superuserTableKeys = map[string]bool{
+	ProgramRunAsKey: true,
+}
+
+// MayChangeTableKey reports whether the player with editorId can set or remove the key in a thing's table data.
+func MayChangeTableKey(editorId ThingId, key string) bool {
+	if !superuserTableKeys[key] {
+		return true
+	}
+	editor := GetThing(editorId)
+	return editor != nil && editor.Superuser
+}
+
+// keepSuperuserTableKeys puts back into table the values of the superuser-only keys in old, for when a player who isn't a superuser replaces a thing's whole table.
+func keepSuperuserTableKeys(table, old map[string]interface{}) {
+	for key := range superuserTableKeys {
+		if value, ok := old[key]; ok {
+			table[key] = value
+		} else {
+			delete(table, key)
+		}
+	}
+}
+
+// ProgramIdentity is who a program acts as in a call, which decides what it's allowed to do.
+type ProgramIdentity struct {
+	// Player is the player whose permissions the program has, or 0 for none.
+	Player ThingId
+	// Wizard is whether the program can do anything, as a wizard's program.
+	Wizard bool
+	// Caller is the player who explicitly called a program that runs as its caller, or 0. Then the program can only do what both the caller & its Player (or a wizard) can.
+	Caller ThingId
+}
+
+// Can reports whether the identity passes the permission check of a player: its Player must pass it (unless it's a wizard's), and so must its Caller, if it has one.
+func (identity ProgramIdentity) Can(check func(playerId ThingId) bool) bool {
+	if identity.Caller != 0 && !check(identity.Caller) {
+		return false
+	}
+	return identity.Wizard || identity.Player != 0 && check(identity.Player)
+}
+
+// ProgramIdentityFor finds who the thing's program acts as in a call the player with callerId explicitly started, as by using an action, or 0 for other calls, such as for events & timers. Only explicit calls to programs that run as their caller are limited to the caller's authority; programs otherwise act as their owner.
+func (thing *Thing) ProgramIdentityFor(callerId ThingId) ProgramIdentity {
+	identity := ProgramIdentity{Player: thing.PlayerId(), Wizard: thing.Wizard}
+	if runAs, _ := thing.Table[ProgramRunAsKey].(string); runAs == "caller" && callerId != 0 {
+		if caller := GetThing(callerId); caller != nil {
+			identity.Caller = caller.PlayerId()
+		}
+	}
+	return identity
+}
+
+// ChangeProgramBy sets the thing's program, as changed by the given player. A wizard's program can only be changed by superusers and stay a wizard's, so no one else can borrow its authority. If the thing is a library, the programs that require it are recompiled to use its new program. The thing's error log is cleared, as its errors were in the old program.
+func (thing *Thing) ChangeProgramBy(editorId ThingId, program *ThingProgram) {
+	if program != nil && program.state != nil {
+		// It was compiled without the thing, such as to check it for errors, so compile it again when it's called, to check the libraries it requires against the thing's authority.
+		program.Close()
+		program = LoadProgram(program.Text)
+	}
+	if thing.Program != nil && thing.Program != program {
+		thing.Program.Close()
+	}
+	thing.Program = program
+	forgetScriptErrors(thing.Id)
+	if err := World.ClearScriptErrors(thing.Id); err != nil {
+		log.Println("Error clearing errors in program of thing", thing.Id, ":", err.Error())
+	}
+	if editor := GetThing(editorId); thing.Wizard && (editor == nil || !editor.Superuser) {
+		thing.Wizard = false
+	}
+	if thing.IsLibrary() {
+		RecompileDependents(thing, editorId)
+	}
+}
+
+// ProgramLimits finds the limits for running the thing's program.
+func (thing *Thing) ProgramLimits() ProgramLimits {
+	limits := DefaultProgramLimits()
+	table, ok := thing.Table[ProgramLimitsKey].(map[string]interface{})
+	if !ok {
+		return limits
+	}
+	owner := thing.GetOwner()
+	canRaise := owner != nil && owner.Superuser
+
+	limit := func(key string, current int) int {
+		value, ok := table[key].(float64)
+		if !ok || value <= 0 || (int(value) > current && !canRaise) {
+			return current
+		}
+		return int(value)
+	}
+	limits.Instructions = limit("instructions", limits.Instructions)
+	limits.MemoryKB = limit("memoryKB", limits.MemoryKB)
+	timeoutMillis := limit("timeoutMillis", int(limits.Timeout/time.Millisecond))
+	limits.Timeout = time.Duration(timeoutMillis) * time.Millisecond
+	return limits
+}
+
 func (thing *Thing) TryToCall(name string, env map[string]interface{}, args ...interface{}) {
+	thing.TryToCallFor(name, env, args...)
+}
+
+// TryToAsk calls the thing's program's function `name` like TryToCall, answering false only if the function returned false. A thing without a program, or whose program fails, answers true.
+func (thing *Thing) TryToAsk(name string, env map[string]interface{}, args ...interface{}) bool {
+	return thing.TryToCallFor(name, env, args...) != false
+}
+
+// TryToCallFor calls the thing's program's function `name` like TryToCall, returning the first value it returned. A thing without a program, or whose program fails, returns nil.
+func (thing *Thing) TryToCallFor(name string, env map[string]interface{}, args ...interface{}) interface{} {
+	return thing.tryToCallBy(0, name, env, args...)
+}
+
+// TryToCallBy calls the thing's program's function `name` like TryToCall, for a call the player with callerId explicitly started, as by using an action. Only these calls can run as their caller (see ProgramRunAsKey).
+func (thing *Thing) TryToCallBy(callerId ThingId, name string, env map[string]interface{}, args ...interface{}) {
+	thing.tryToCallBy(callerId, name, env, args...)
+}
+
+func (thing *Thing) tryToCallBy(callerId ThingId, name string, env map[string]interface{}, args ...interface{}) interface{} {
 	prog := thing.Program
 	if prog == nil {
 		// No program to run, a-OK.
-		return
+		return nil
 	}
 
-	err := prog.TryToCall(name, env, args...)
+	prog.Thing = thing.Id
+	prog.Identity = thing.ProgramIdentityFor(callerId)
+	prog.Limits = thing.ProgramLimits()
+	result, err := prog.TryToCallFor(name, env, args...)
 	if err == nil {
 		// Success!
+		return result
+	}
+	thing.reportProgramError(err, env)
+	return nil
+}
+
+// reportProgramError logs the error in the thing's program to its error log, and tells the thing's owner (or the thing, if it's a player) about it if they're connected. Errors repeating within the ScriptErrorPeriod are dropped (see noteScriptError).
+func (thing *Thing) reportProgramError(err error, env map[string]interface{}) {
+	text := ""
+	if thing.Program != nil {
+		text = thing.Program.Text
+	}
+	scriptErr := NewScriptError(err, "", text)
+	scriptErr.Thing = thing.Id
+	scriptErr.Created = time.Now().UTC()
+	if callerId, ok := env["me"].(ThingId); ok {
+		if caller := GetThing(callerId); caller != nil {
+			scriptErr.Player = caller.PlayerId()
+		}
+	}
+	if !noteScriptError(scriptErr) {
+		// It's failing over & over, so it's already been logged & reported.
 		return
 	}
+	saveErr := World.SaveScriptError(scriptErr)
+	if saveErr != nil {
+		log.Println("Error saving error in program of thing", thing.Id, ":", saveErr.Error())
+	}
 
 	// Notify the thing's owner of the error.
 	owner := thing
 	if thing.Type != PlayerThing {
-		owner = World.ThingForId(thing.Owner)
+		owner = GetThing(thing.Owner)
+	}
+	if owner == nil {
+		return
 	}
 	ownClient := owner.Client
 	if ownClient != nil {
-		ownClient.Send(fmt.Sprintf("Error with your program '%s': %s",
-			thing.Name, err.Error()))
+		ownClient.Send(fmt.Sprintf("Error with your program '%s' in %s: %s",
+			thing.Name, scriptErr.Where(), scriptErr.Error()))
 	}
 }
 
 var World WorldStore
 var Accounts AccountStore
 
-func GameInit() {
-	db, err := OpenDatabase()
+// GetThing finds the thing with the given id in the World, or nil if there's no such thing or it can't be loaded right now. Callers must check for nil, as things can go missing even when they're listed in other things' contents.
+func GetThing(id ThingId) *Thing {
+	thing, err := World.ThingForId(id)
+	if StoreFailureOf(err) == StoreNotFound {
+		return nil
+	} else if err != nil {
+		log.Println("Couldn't load thing", id, ":", err.Error())
+		return nil
+	}
+	return thing
+}
+
+// SaveThingBy saves the thing as changed by the player with editorId, recording who changed it in the thing's history.
+func SaveThingBy(editorId ThingId, thing *Thing) error {
+	if active, ok := World.(*ActiveWorld); ok {
+		return active.SaveThingBy(thing, editorId)
+	}
+	return World.SaveThing(thing)
+}
+
+// StoreErrorMessage describes an error from the World or Accounts for players.
+func StoreErrorMessage(err error) string {
+	switch StoreFailureOf(err) {
+	case StoreNotFound:
+		return "That doesn't seem to exist."
+	case StoreDuplicateLogin:
+		return "Someone already has that name."
+	case StoreConstraintViolation:
+		return fmt.Sprintf("That isn't allowed (%s).", err.(*StoreError).Err.Error())
+	}
+	return "The mess is having trouble with its database right now. Try again later."
+}
+
+func GameInit() error {
+	worldStore, accountStore, err := OpenStores()
 	if err != nil {
-		log.Println("Error connecting to database:", err)
-		return
+		return err
 	}
 
-	World = &ActiveWorld{
-		Things: make(map[ThingId]*Thing),
-		Next:   db,
+	cacheSize := Config.CacheSize
+	if cacheSize == 0 {
+		cacheSize = 10000
+	}
+	missingExpiry := time.Duration(Config.CacheMissingSeconds) * time.Second
+	if missingExpiry == 0 {
+		missingExpiry = time.Minute
+	}
+	savePeriod := time.Duration(Config.SaveSeconds) * time.Second
+	if savePeriod == 0 {
+		savePeriod = 5 * time.Second
 	}
-	Accounts = db
+
+	active := NewActiveWorld(worldStore, cacheSize, missingExpiry, savePeriod)
+	go active.KeepSaving()
+
+	World = active
+	Accounts = accountStore
+	return Timers.Load()
 }
 
 func Identify(source *Thing, name string) *Thing {
@@ -341,7 +771,7 @@ func Identify(source *Thing, name string) *Thing {
 		return source
 	}
 	if nameLower == "here" {
-		return World.ThingForId(source.Parent)
+		return GetThing(source.Parent)
 	}
 	return source.FindNear(name)
 }
@@ -358,7 +788,8 @@ func GameLook(client *ClientPump, char *Thing, rest string) {
 	}
 
 	client.Send(target.Name)
-	desc, ok := target.Table["description"].(string)
+	descValue, _ := target.Lookup("description")
+	desc, ok := descValue.(string)
 	if !ok || desc == "" {
 		desc = "You see nothing special."
 	}
@@ -375,127 +806,399 @@ func GameSay(client *ClientPump, char *Thing, rest string) {
 	client.Send(fmt.Sprintf("You say, \"%s\"", rest))
 
 	text := fmt.Sprintf("%s says, \"%s\"", char.Name, rest)
-	parent := World.ThingForId(char.Parent)
-	for _, otherId := range parent.Contents {
-		if otherId == char.Id {
-			continue
-		}
-		otherChar := World.ThingForId(otherId)
-		if otherChar.Client != nil {
-			otherChar.Client.Send(text)
+	if parent := GetThing(char.Parent); parent != nil {
+		for _, otherId := range parent.Contents {
+			if otherId == char.Id {
+				continue
+			}
+			if otherChar := GetThing(otherId); otherChar != nil && otherChar.Client != nil {
+				otherChar.Client.Send(text)
+			}
 		}
 	}
+
+	NotifySaid(char, rest)
 }
 
-func GameClient(client *ClientPump, account *Account) {
-	char := World.ThingForId(account.Character)
-	if char.Client != nil {
-		// TODO: kill the old one???
+func GameRecycle(client *ClientPump, char *Thing, rest string) {
+	if rest == "" {
+		client.Send("To recycle something, type: @recycle thing")
+		return
 	}
-	char.Client = client
+	target := Identify(char, rest)
 
-	// We just arrived from the welcome screen, so "look" around.
-	// TODO: motd?
-	GameLook(client, char, "")
+	if target == nil {
+		client.Send(fmt.Sprintf("Not sure what you meant by \"%s\".", rest))
+		return
+	}
+	if target.Id == char.Id {
+		client.Send("You can't recycle yourself.")
+		return
+	}
+	if !target.DestroyableById(char.Id) {
+		client.Send("You can't recycle that.")
+		return
+	}
 
-Input:
-	for input := range client.ToServer {
-		if input == "QUIT" {
-			client.Send("Thanks for spending time with the mess today!")
-			client.Close()
+	name := target.Name
+	err := RecycleThing(target)
+	if err != nil {
+		client.Send(fmt.Sprintf("Oops, %s couldn't be recycled. %s", name, StoreErrorMessage(err)))
+		return
+	}
+	client.Send(fmt.Sprintf("%s has been recycled.", name))
+}
+
+// RecycleThing destroys the thing, then tells its parent it was destroyed. The store can still refuse, so the parent's only told once it's really gone.
+func RecycleThing(thing *Thing) error {
+	err := World.DestroyThing(thing)
+	if err != nil {
+		return err
+	}
+	NotifyDestroyed(thing)
+	return nil
+}
+
+// GameWizard makes a thing a wizard, so its program can do anything, or stops it being one.
+func GameWizard(client *ClientPump, char *Thing, rest string) {
+	if !char.Superuser {
+		client.Send("Only superusers can make wizards.")
+		return
+	}
+	parts := strings.SplitN(rest, "=", 2)
+	if len(parts) < 2 {
+		client.Send("To make a thing a wizard or not, type: @wizard thing = on|off")
+		return
+	}
+	name, setting := strings.TrimSpace(parts[0]), strings.ToLower(strings.TrimSpace(parts[1]))
+	if setting != "on" && setting != "off" {
+		client.Send("To make a thing a wizard or not, type: @wizard thing = on|off")
+		return
+	}
+
+	target := Identify(char, name)
+	if target == nil {
+		client.Send(fmt.Sprintf("Not sure what you meant by \"%s\".", name))
+		return
+	}
+
+	target.Wizard = setting == "on"
+	err := SaveThingBy(char.Id, target)
+	if err != nil {
+		client.Send(StoreErrorMessage(err))
+		return
+	}
+	if target.Wizard {
+		client.Send(fmt.Sprintf("%s is now a wizard, so its program can do anything.", target.Name))
+	} else {
+		client.Send(fmt.Sprintf("%s is no longer a wizard.", target.Name))
+	}
+}
+
+func GameCache(client *ClientPump, char *Thing, rest string) {
+	if !char.Superuser {
+		client.Send("Only superusers can manage the cache.")
+		return
+	}
+	active, ok := World.(*ActiveWorld)
+	if !ok {
+		client.Send("This mess has no cache to manage.")
+		return
+	}
+
+	parts := strings.Fields(rest)
+	if len(parts) == 0 {
+		stats := active.CacheStats()
+		client.Send(fmt.Sprintf("%d things in memory (limit %d), %d known missing.",
+			stats.Things, active.MaxThings, stats.Missing))
+		client.Send(fmt.Sprintf("%d hits, %d missing hits, %d misses, %d evictions.",
+			stats.Hits, stats.MissingHits, stats.Misses, stats.Evictions))
+		client.Send(fmt.Sprintf("%d things with unsaved changes.", stats.Unsaved))
+		return
+	}
+	if len(parts) == 1 && strings.ToLower(parts[0]) == "save" {
+		err := active.SaveDirty()
+		if err != nil {
+			client.Send(fmt.Sprintf("Oops, some changes couldn't be saved. %s", StoreErrorMessage(err)))
 			return
 		}
+		client.Send("Saved all changed things.")
+		return
+	}
+	if len(parts) != 2 {
+		client.Send("To manage the cache, type: @cache, @cache save, @cache flush #id, or @cache reload #id")
+		return
+	}
+
+	id64, err := strconv.ParseInt(strings.TrimPrefix(parts[1], "#"), 10, 64)
+	if err != nil {
+		client.Send(fmt.Sprintf("Not sure what thing you meant by \"%s\".", parts[1]))
+		return
+	}
+	thingId := ThingId(id64)
+
+	switch strings.ToLower(parts[0]) {
+	case "flush":
+		err := active.Flush(thingId)
+		if err != nil {
+			client.Send(fmt.Sprintf("Oops, #%d couldn't be flushed. %s", thingId, StoreErrorMessage(err)))
+			return
+		}
+		client.Send(fmt.Sprintf("Flushed #%d from the cache.", thingId))
+	case "reload":
+		thing, err := active.Reload(thingId)
+		if err != nil {
+			client.Send(fmt.Sprintf("Oops, #%d couldn't be reloaded. %s", thingId, StoreErrorMessage(err)))
+			return
+		}
+		client.Send(fmt.Sprintf("Reloaded %s (#%d) from the database.", thing.Name, thingId))
+	default:
+		client.Send("To manage the cache, type: @cache, @cache save, @cache flush #id, or @cache reload #id")
+	}
+}
+
+const findUsage = "To find things, type: @find [name or start*] [type:place] [owner:me|#id] [creator:me|#id] [in:here|#id] [key:name] [value:value] [limit:n]. Searching by key only finds things you can edit."
+
+// ParseFindThing reads a thing a player named in a search: "me", "here" or "#id".
+func ParseFindThing(char *Thing, text string) (ThingId, bool) {
+	switch strings.ToLower(text) {
+	case "me":
+		return char.Id, true
+	case "here":
+		return char.Parent, true
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(text, "#"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return ThingId(id), true
+}
+
+func GameFind(client *ClientPump, char *Thing, rest string) {
+	query := &ThingQuery{}
+	var nameWords []string
+	for _, word := range strings.Fields(rest) {
+		parts := strings.SplitN(word, ":", 2)
+		if len(parts) < 2 {
+			nameWords = append(nameWords, word)
+			continue
+		}
 
-		parts := strings.SplitN(input, " ", 2)
-		command := strings.ToLower(parts[0])
-		rest := ""
-		if len(parts) > 1 {
-			rest = parts[1]
+		var ok bool
+		switch strings.ToLower(parts[0]) {
+		case "type":
+			query.Type = ThingTypeForName(strings.ToLower(parts[1]))
+			ok = true
+		case "owner":
+			query.Owner, ok = ParseFindThing(char, parts[1])
+		case "creator":
+			query.Creator, ok = ParseFindThing(char, parts[1])
+		case "in":
+			query.Parent, ok = ParseFindThing(char, parts[1])
+		case "key":
+			query.TableKey, ok = parts[1], parts[1] != ""
+		case "value":
+			query.TableValue, ok = ParseTableValue(parts[1]), true
+		case "limit":
+			var err error
+			query.Limit, err = strconv.Atoi(parts[1])
+			ok = err == nil
+		default:
+			// Not a filter, so it's part of the name, like "Note:".
+			nameWords = append(nameWords, word)
+			ok = true
+		}
+		if !ok {
+			client.Send(fmt.Sprintf("Not sure what you meant by \"%s\".", word))
+			client.Send(findUsage)
+			return
 		}
-		log.Println("Unused portion of command:", rest)
+	}
+
+	name := strings.Join(nameWords, " ")
+	if strings.HasSuffix(name, "*") {
+		query.NamePrefix = strings.TrimSuffix(name, "*")
+	} else {
+		query.NameContains = name
+	}
+	if *query == (ThingQuery{}) {
+		client.Send(findUsage)
+		return
+	}
 
-		switch command {
-		case "look":
-			GameLook(client, char, rest)
-			continue Input
-		case "say":
-			GameSay(client, char, rest)
-			continue Input
+	things, more, err := FindThingsBy(query, func(thing *Thing) bool {
+		return thing.EditableById(char.Id)
+	})
+	if err != nil {
+		client.Send(fmt.Sprintf("Oops, couldn't search for that. %s", StoreErrorMessage(err)))
+		return
+	}
+	if len(things) == 0 {
+		client.Send("Found nothing like that.")
+		return
+	}
+	for _, thing := range things {
+		client.Send(fmt.Sprintf("%s (#%d, %s)", thing.Name, thing.Id, thing.Type))
+	}
+	if more {
+		client.Send("The search stopped at its limit; there may be more.")
+	}
+}
+
+// GameCommand does one command a connected player typed. The world must be locked (see WithWorld).
+func GameCommand(client *ClientPump, char *Thing, input string) {
+	parts := strings.SplitN(input, " ", 2)
+	command := strings.ToLower(parts[0])
+	rest := ""
+	if len(parts) > 1 {
+		rest = parts[1]
+	}
+	log.Println("Unused portion of command:", rest)
+
+	switch command {
+	case "look":
+		GameLook(client, char, rest)
+		return
+	case "say":
+		GameSay(client, char, rest)
+		return
+	case "@recycle":
+		GameRecycle(client, char, rest)
+		return
+	case "@cache":
+		GameCache(client, char, rest)
+		return
+	case "@find":
+		GameFind(client, char, rest)
+		return
+	case "@wizard":
+		GameWizard(client, char, rest)
+		return
+	}
+
+	// Look up the environment for an action with that command.
+	var action *Thing
+	thisThing := char
+FindActionUp:
+	for thisThing != nil {
+		for _, candAction := range thisThing.GetContents() {
+			if candAction.ActionMatches(command) {
+				action = candAction
+				break FindActionUp
+			}
 		}
 
-		// Look up the environment for an action with that command.
-		var action *Thing
-		thisThing := char
-	FindActionUp:
-		for thisThing != nil {
-			for _, candAction := range thisThing.GetContents() {
+		// No actions on thisThing matched. Try up the environment.
+		thisThing = GetThing(thisThing.Parent)
+	}
+	if here := GetThing(char.Parent); action == nil && here != nil {
+	FindActionHere:
+		for _, hostThing := range here.GetContents() {
+			if hostThing.Type != RegularThing {
+				continue FindActionHere
+			}
+			for _, candAction := range hostThing.GetActions() {
 				if candAction.ActionMatches(command) {
 					action = candAction
-					break FindActionUp
+					break FindActionHere
 				}
 			}
+		}
+	}
+	if action == nil {
+		log.Println("Found no action", command, ", womp womp")
+		client.Send(fmt.Sprintf("Oops, not sure what you mean by \"%s\".", command))
+		return
+	}
+
+	// Can I use this action?
+	if !action.AllowedById(char.Id) {
+		// TODO: action failure messages? once we have them? maybe?
+		client.Send(fmt.Sprintf("You can't use that."))
+		return
+	}
+
+	target := action.ActionTarget()
+	if target == nil {
+		client.Send(fmt.Sprintf("Nothing happens."))
+		return
+	}
+	log.Println("Action", command, "has target", target)
 
-			// No actions on thisThing matched. Try up the environment.
-			thisThing = World.ThingForId(thisThing.Parent)
+	// Can we use that target?
+	if !target.AllowedById(char.Id) {
+		// TODO: action failure messages? once we have them? maybe?
+		client.Send(fmt.Sprintf("You can't use that."))
+		return
+	}
+
+	// TODO: move to target.Parent if PlayerThing or RegularThing?
+	switch target.Type {
+	case PlaceThing:
+		log.Println("Target is a place, moving player there")
+		err := char.MoveTo(target)
+		if _, ok := err.(*MoveError); ok {
+			client.Send(err.Error())
+			return
+		} else if err != nil {
+			client.Send(StoreErrorMessage(err))
+			return
 		}
-		if action == nil {
-			here := World.ThingForId(char.Parent)
-		FindActionHere:
-			for _, hostThing := range here.GetContents() {
-				if hostThing.Type != RegularThing {
-					continue FindActionHere
-				}
-				for _, candAction := range hostThing.GetActions() {
-					if candAction.ActionMatches(command) {
-						action = candAction
-						break FindActionHere
-					}
-				}
-			}
+		GameLook(client, char, "")
+	case ProgramThing:
+		log.Println("Target is a program object")
+		target.TryToCallBy(char.Id, "Run", map[string]interface{}{
+			"me":      char.Id,
+			"here":    char.Parent,
+			"target":  action.Id, // the "trigger"
+			"command": parts[0],  // un-lowered
+		}, rest)
+	default: // player, action, regular thing
+		client.Send(fmt.Sprintf("Nothing happens."))
+	}
+}
+
+func GameClient(client *ClientPump, account *Account) {
+	var char *Thing
+	var err error
+	WithWorld(func() {
+		char, err = World.ThingForId(account.Character)
+		if err != nil {
+			return
 		}
-		if action == nil {
-			log.Println("Found no action", command, ", womp womp")
-			client.Send(fmt.Sprintf("Oops, not sure what you mean by \"%s\".", command))
-			continue Input
-		}
-
-		// Can I use this action?
-		if action.DeniedById(char.Id) {
-			// TODO: action failure messages? once we have them? maybe?
-			client.Send(fmt.Sprintf("You can't use that."))
-			continue Input
-		}
-
-		target := action.ActionTarget()
-		if target == nil {
-			client.Send(fmt.Sprintf("Nothing happens."))
-			continue Input
-		}
-		log.Println("Action", command, "has target", target)
-
-		// Can we use that target?
-		if target.DeniedById(char.Id) {
-			// TODO: action failure messages? once we have them? maybe?
-			client.Send(fmt.Sprintf("You can't use that."))
-			continue Input
-		}
-
-		// TODO: move to target.Parent if PlayerThing or RegularThing?
-		switch target.Type {
-		case PlaceThing:
-			log.Println("Target is a place, moving player there")
-			char.MoveTo(target)
-			GameLook(client, char, "")
-		case ProgramThing:
-			log.Println("Target is a program object")
-			target.TryToCall("Run", map[string]interface{}{
-				"me":      char.Id,
-				"here":    char.Parent,
-				"target":  action.Id, // the "trigger"
-				"command": parts[0],  // un-lowered
-			}, rest)
-		default: // player, action, regular thing
-			client.Send(fmt.Sprintf("Nothing happens."))
+		if char.Client != nil {
+			// TODO: kill the old one???
 		}
+		char.Client = client
+
+		// We just arrived from the welcome screen, so "look" around.
+		// TODO: motd?
+		GameLook(client, char, "")
+		NotifyConnected(char, true)
+	})
+	if err != nil {
+		client.Send(StoreErrorMessage(err))
+		client.Close()
+		return
+	}
+
+	// Once the client goes away, the character is no longer connected (unless they've connected again since).
+	defer WithWorld(func() {
+		if char.Client == client {
+			char.Client = nil
+			NotifyConnected(char, false)
+		}
+	})
+
+	for input := range client.ToServer {
+		if input == "QUIT" {
+			client.Send("Thanks for spending time with the mess today!")
+			client.Close()
+			return
+		}
+
+		WithWorld(func() {
+			GameCommand(client, char, input)
+		})
 	}
 }
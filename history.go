@@ -0,0 +1,246 @@
+package mess
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxThingRevisions is how many revisions of each thing are kept. Older ones are forgotten as new ones are saved, so a program changing its thing over & over can't fill up the store.
+const MaxThingRevisions = 100
+
+// ThingRevision is a version of a thing as it was saved, recorded in the thing's history so the change can be seen & undone.
+type ThingRevision struct {
+	Id      int64
+	Thing   ThingId
+	Editor  ThingId
+	Created time.Time
+
+	Name      string
+	Owner     ThingId
+	AdminList ThingIdList
+	AllowList ThingIdList
+	DenyList  ThingIdList
+	Table     map[string]interface{}
+	// Program is the text of the thing's program, or empty if it had none.
+	Program string
+}
+
+// NewRevision records the current version of thing, as changed by the player with editorId (or 0 if no player changed it).
+func NewRevision(thing *Thing, editorId ThingId) (*ThingRevision, error) {
+	tabletext, err := EncodeTable(thing.Table)
+	if err != nil {
+		return nil, err
+	}
+	table, err := DecodeTable(tabletext)
+	if err != nil {
+		return nil, err
+	}
+
+	rev := &ThingRevision{
+		Thing:     thing.Id,
+		Editor:    editorId,
+		Created:   time.Now().UTC(),
+		Name:      thing.Name,
+		Owner:     thing.Owner,
+		AdminList: copyThingIdList(thing.AdminList),
+		AllowList: copyThingIdList(thing.AllowList),
+		DenyList:  copyThingIdList(thing.DenyList),
+		Table:     table,
+	}
+	if thing.Program != nil {
+		rev.Program = thing.Program.Text
+	}
+	return rev, nil
+}
+
+func (rev *ThingRevision) GetEditor() *Thing {
+	return GetThing(rev.Editor)
+}
+
+// Revert changes the thing back to how it was at this revision, and saves it as changed by the given player. Only the thing's owner can change back its owner & access lists, and only superusers the table keys that only they can set; for anyone else, those stay as they are.
+func (rev *ThingRevision) Revert(thing *Thing, editorId ThingId) error {
+	tabletext, err := EncodeTable(rev.Table)
+	if err != nil {
+		return &StoreError{StoreConstraintViolation, err}
+	}
+	table, err := DecodeTable(tabletext)
+	if err != nil {
+		return &StoreError{StoreConstraintViolation, err}
+	}
+
+	thing.Name = rev.Name
+	if editor := GetThing(editorId); editor == nil || !editor.Superuser {
+		keepSuperuserTableKeys(table, thing.Table)
+	}
+	thing.Table = table
+	if rev.Program == "" {
+		thing.ChangeProgramBy(editorId, nil)
+	} else if thing.Program == nil || thing.Program.Text != rev.Program {
+		thing.ChangeProgramBy(editorId, NewProgram(rev.Program))
+	}
+	if thing.OwnedById(editorId) {
+		if thing.Type.HasOwner() {
+			thing.Owner = rev.Owner
+		}
+		thing.AdminList = copyThingIdList(rev.AdminList)
+		thing.AllowList = copyThingIdList(rev.AllowList)
+		thing.DenyList = copyThingIdList(rev.DenyList)
+	}
+
+	return SaveThingBy(editorId, thing)
+}
+
+// DiffLine is one line of a diff between two texts. Kind is "+" for an added line, "-" for a removed one, or " " for one that's in both.
+type DiffLine struct {
+	Kind string
+	Text string
+}
+
+// RevisionChange is one way a revision differs from the one before it, for showing in the thing's history.
+type RevisionChange struct {
+	Field string
+	Old   string
+	New   string
+	// Lines is the line-by-line diff of Old & New, for long text like programs.
+	Lines []DiffLine
+}
+
+func describeIdList(l ThingIdList) string {
+	names := make([]string, len(l))
+	for i, id := range l {
+		if thing := GetThing(id); thing != nil {
+			names[i] = fmt.Sprintf("%s (#%d)", thing.Name, id)
+		} else {
+			names[i] = fmt.Sprintf("#%d", id)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func describeTableValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	text, err := json.Marshal(encodeThingRefs(value))
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(text)
+}
+
+// ChangesSince finds how this revision differs from the older one. If older is nil, this is the first revision, so everything in it counts as a change.
+func (rev *ThingRevision) ChangesSince(older *ThingRevision) (changes []RevisionChange) {
+	if older == nil {
+		older = &ThingRevision{Table: make(map[string]interface{})}
+	}
+
+	if rev.Name != older.Name {
+		changes = append(changes, RevisionChange{Field: "Name", Old: older.Name, New: rev.Name})
+	}
+	if rev.Owner != older.Owner {
+		changes = append(changes, RevisionChange{
+			Field: "Owner",
+			Old:   describeIdList(ThingIdList{older.Owner}.Without(0)),
+			New:   describeIdList(ThingIdList{rev.Owner}.Without(0)),
+		})
+	}
+
+	lists := []struct {
+		field    string
+		old, new ThingIdList
+	}{
+		{"Admins", older.AdminList, rev.AdminList},
+		{"Allowed", older.AllowList, rev.AllowList},
+		{"Denied", older.DenyList, rev.DenyList},
+	}
+	for _, list := range lists {
+		oldText, newText := describeIdList(list.old), describeIdList(list.new)
+		if oldText != newText {
+			changes = append(changes, RevisionChange{Field: list.field, Old: oldText, New: newText})
+		}
+	}
+
+	keys := make(map[string]bool)
+	for key := range older.Table {
+		keys[key] = true
+	}
+	for key := range rev.Table {
+		keys[key] = true
+	}
+	var sortedKeys []string
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+	for _, key := range sortedKeys {
+		oldText, newText := describeTableValue(older.Table[key]), describeTableValue(rev.Table[key])
+		if oldText != newText {
+			changes = append(changes, RevisionChange{Field: fmt.Sprintf("Data: %s", key), Old: oldText, New: newText})
+		}
+	}
+
+	if rev.Program != older.Program {
+		changes = append(changes, RevisionChange{
+			Field: "Program",
+			Old:   older.Program,
+			New:   rev.Program,
+			Lines: DiffLines(older.Program, rev.Program),
+		})
+	}
+
+	return
+}
+
+// DiffLines compares two texts line by line, finding the fewest lines to add & remove to change one into the other.
+func DiffLines(oldText, newText string) (diff []DiffLine) {
+	var oldLines, newLines []string
+	if oldText != "" {
+		oldLines = strings.Split(oldText, "\n")
+	}
+	if newText != "" {
+		newLines = strings.Split(newText, "\n")
+	}
+
+	// common[i][j] is the length of the longest common subsequence of oldLines[i:] & newLines[j:].
+	common := make([][]int, len(oldLines)+1)
+	for i := range common {
+		common[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(oldLines) && j < len(newLines) {
+		switch {
+		case oldLines[i] == newLines[j]:
+			diff = append(diff, DiffLine{" ", oldLines[i]})
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			diff = append(diff, DiffLine{"-", oldLines[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{"+", newLines[j]})
+			j++
+		}
+	}
+	for ; i < len(oldLines); i++ {
+		diff = append(diff, DiffLine{"-", oldLines[i]})
+	}
+	for ; j < len(newLines); j++ {
+		diff = append(diff, DiffLine{"+", newLines[j]})
+	}
+	return
+}
@@ -0,0 +1,174 @@
+package mess
+
+import (
+	"fmt"
+	"github.com/aarzilli/golua/lua"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// LibraryKey is the table key that makes a program thing a library, which other programs can load with `require`, by its name or id.
+const LibraryKey = "library"
+
+// IsLibrary reports whether the thing is a program other programs can require.
+func (thing *Thing) IsLibrary() bool {
+	isLibrary, _ := thing.Table[LibraryKey].(bool)
+	return thing.Type == ProgramThing && isLibrary
+}
+
+// FindLibrary finds the library with the given name (ignoring case), or id as "#12". If several libraries have the name, the oldest is found.
+func FindLibrary(name string) (*Thing, error) {
+	if strings.HasPrefix(name, "#") {
+		if id, err := strconv.ParseInt(name[1:], 10, 64); err == nil {
+			if thing := GetThing(ThingId(id)); thing != nil && thing.IsLibrary() {
+				return thing, nil
+			}
+			return nil, fmt.Errorf("there's no library %s", name)
+		}
+	}
+
+	things, err := FindThingsFor(&ThingQuery{
+		Name:       name,
+		Type:       ProgramThing,
+		TableKey:   LibraryKey,
+		TableValue: true,
+		Limit:      1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(things) == 0 {
+		return nil, fmt.Errorf("there's no library named %q", name)
+	}
+	return things[0], nil
+}
+
+// Requires reports whether the program has loaded the library with the given id, directly or through another library.
+func (p *ThingProgram) Requires(id ThingId) bool {
+	_, ok := p.libraries[id]
+	return ok
+}
+
+// libraryChunkPrefix is put at the start of a library's first line when it's loaded, so Lua names its chunk for the library. That way errors in the library can be told from errors in the program that required it (see NewScriptError), and its lines are still numbered the same.
+const libraryChunkPrefix = "--[[library #%d]] "
+
+// installRequire sets the global `require`, which loads a library into the program, as `local strings = require "strings"` (or by its id, as "#12", or its Thing). A library is run once per program, and its result (or true, if it returns nothing) is what require returns from then on. Wizards' & superusers' programs can only require their own player's or superusers' libraries (see mayRunCodeBy).
+func (p *ThingProgram) installRequire(state *lua.State) {
+	state.PushGoClosure(func(state *lua.State) int {
+		// ( name|thing -- )
+		var library *Thing
+		if id, ok := toThingId(state, 1); ok {
+			library = GetThing(id)
+			if library == nil || !library.IsLibrary() {
+				state.ArgError(1, "that thing isn't a library")
+			}
+		} else {
+			var err error
+			library, err = FindLibrary(state.CheckString(1))
+			if err != nil {
+				state.ArgError(1, err.Error())
+			}
+		}
+		if !library.AllowedById(p.identity().Player) {
+			state.RaiseError(fmt.Sprintf("the program isn't allowed to use the library %s", library.Name))
+			return 0
+		}
+
+		if self := GetThing(p.Thing); self != nil && !self.mayRunCodeBy(library.PlayerId()) {
+			state.RaiseError(fmt.Sprintf("the library %s belongs to someone else, so it can't be used by a wizard's or superuser's program", library.Name))
+			return 0
+		}
+
+		if ref, ok := p.libraries[library.Id]; ok {
+			state.RawGeti(lua.LUA_REGISTRYINDEX, ref) // ( -- val )
+			return 1
+		}
+
+		for i, loadingId := range p.loading {
+			if loadingId != library.Id {
+				continue
+			}
+			var names []string
+			for _, id := range append(p.loading[i:], library.Id) {
+				names = append(names, fmt.Sprintf("#%d", id))
+			}
+			state.RaiseError(fmt.Sprintf("libraries can't require each other in a cycle: %s", strings.Join(names, " → ")))
+			return 0
+		}
+
+		text := ""
+		if library.Program != nil {
+			text = library.Program.Text
+		}
+		if state.LoadString(fmt.Sprintf(libraryChunkPrefix, library.Id)+text) != 0 { // ( -- func | strErr )
+			message := state.ToString(-1)
+			state.Pop(1)
+			state.RaiseError(fmt.Sprintf("couldn't load the library %s: %s", library.Name, message))
+			return 0
+		}
+
+		p.loading = append(p.loading, library.Id)
+		err := p.limitError(state.Call(0, 1)) // ( func -- val | strErr )
+		p.loading = p.loading[:len(p.loading)-1]
+		if err != nil {
+			state.Pop(1)
+			state.RaiseError(fmt.Sprintf("couldn't run the library %s: %s", library.Name, err.Error()))
+			return 0
+		}
+
+		if state.IsNil(-1) {
+			state.Pop(1)
+			state.PushBoolean(true) // ( nil -- true )
+		}
+		state.PushValue(-1)                                        // ( val -- val val )
+		p.libraries[library.Id] = state.Ref(lua.LUA_REGISTRYINDEX) // ( val val -- val )
+		return 1
+	})
+	state.SetGlobal("require")
+}
+
+// mayRunCodeBy reports whether code by the player with playerId, such as a library they own, can run in the thing's program. Libraries run with the authority of the programs that require them, so a wizard's or superuser's program can only run code by its own player or superusers.
+func (thing *Thing) mayRunCodeBy(playerId ThingId) bool {
+	if playerId != 0 && playerId == thing.PlayerId() {
+		return true
+	}
+	if author := GetThing(playerId); author != nil && author.Superuser {
+		return true
+	}
+	if thing.Wizard {
+		return false
+	}
+	player := GetThing(thing.PlayerId())
+	return player == nil || !player.Superuser
+}
+
+// RecompileDependents makes the loaded programs that required the library compile again before their next call, so they use its new program, as changed by the player with editorId. Programs that aren't loaded will load the new program anyway. A wizard's program whose player can't trust the editor's code (see mayRunCodeBy) is a wizard's no longer.
+func RecompileDependents(library *Thing, editorId ThingId) {
+	active, ok := World.(*ActiveWorld)
+	if !ok {
+		return
+	}
+
+	var dependents []*Thing
+	active.Lock()
+	for _, thing := range active.Things {
+		if thing.Program != nil && thing.Id != library.Id && thing.Program.Requires(library.Id) {
+			dependents = append(dependents, thing)
+		}
+	}
+	active.Unlock()
+
+	for _, thing := range dependents {
+		old := thing.Program
+		thing.Program = LoadProgram(old.Text)
+		old.Close()
+		if thing.Wizard && !thing.mayRunCodeBy(editorId) {
+			thing.Wizard = false
+			err := World.SaveThing(thing)
+			if err != nil {
+				log.Println("Error saving thing", thing.Id, "no longer a wizard's:", err.Error())
+			}
+		}
+	}
+}
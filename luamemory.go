@@ -0,0 +1,44 @@
+package mess
+
+// #include <stdlib.h>
+import "C"
+
+import (
+	"github.com/aarzilli/golua/lua"
+	"unsafe"
+)
+
+// luaMemory counts the memory a Lua state has allocated, so it can be refused more once it's used up its limit.
+type luaMemory struct {
+	used int
+	// limit is how many bytes the state can use, or 0 for no limit.
+	limit int
+	// refused is set when an allocation was refused for going over the limit.
+	refused bool
+}
+
+// allocator is a Lua allocator that keeps the state within the memory limit. Lua raises a "not enough memory" error when an allocation fails.
+func (m *luaMemory) allocator() lua.Alloc {
+	return func(ptr unsafe.Pointer, osize uint, nsize uint) unsafe.Pointer {
+		// When allocating a new block, some Lua versions pass the kind of object in osize instead of a size.
+		if ptr == nil {
+			osize = 0
+		}
+
+		if nsize == 0 {
+			C.free(ptr)
+			m.used -= int(osize)
+			return nil
+		}
+		if m.limit > 0 && nsize > osize && m.used+int(nsize-osize) > m.limit {
+			m.refused = true
+			return nil
+		}
+
+		newPtr := C.realloc(ptr, C.size_t(nsize))
+		if newPtr != nil {
+			m.used += int(nsize) - int(osize)
+		}
+		return newPtr
+	}
+}
@@ -0,0 +1,652 @@
+package mess
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jameskeane/bcrypt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryThing is the stored form of a Thing in a MemoryWorld. Like a database row, it holds the thing's data but not its live state (contents, compiled program, client).
+type memoryThing struct {
+	Thing
+	tabledata []byte
+	program   *string
+}
+
+// memoryRevision is the stored form of a ThingRevision in a MemoryWorld.
+type memoryRevision struct {
+	ThingRevision
+	tabledata []byte
+}
+
+// MemoryWorld is a WorldStore and AccountStore that keeps the whole world in process memory. It's for running a mess without a database, such as for development & tests. Nothing is saved when the process exits.
+type MemoryWorld struct {
+	sync.Mutex
+	things   map[ThingId]*memoryThing
+	accounts map[string]*Account
+	lastId   ThingId
+
+	revisions      []*memoryRevision
+	lastRevisionId int64
+
+	timers map[int64]Timer
+
+	scriptErrors    []*ScriptError
+	lastScriptError int64
+}
+
+// NewMemoryWorld creates an empty in-memory world containing only the first place, just as a newly installed database does.
+func NewMemoryWorld() (w *MemoryWorld) {
+	w = &MemoryWorld{
+		things:   make(map[ThingId]*memoryThing),
+		accounts: make(map[string]*Account),
+		timers:   make(map[int64]Timer),
+	}
+
+	origin := w.newRow("Room One", PlaceThing)
+	w.things[origin.Id] = origin
+
+	return
+}
+
+func copyThingIdList(l ThingIdList) ThingIdList {
+	if l == nil {
+		return nil
+	}
+	ret := make(ThingIdList, len(l))
+	copy(ret, l)
+	return ret
+}
+
+func (w *MemoryWorld) newRow(name string, tt ThingType) *memoryThing {
+	w.lastId++
+	row := &memoryThing{
+		tabledata: []byte("{}"),
+	}
+	row.Id = w.lastId
+	row.Name = name
+	row.Type = tt
+	row.Created = time.Now().UTC()
+	row.AdminList = ThingIdList{}
+	row.AllowList = ThingIdList{}
+	row.DenyList = ThingIdList{}
+	return row
+}
+
+func (w *MemoryWorld) ThingForId(id ThingId) (*Thing, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	row, ok := w.things[id]
+	if !ok {
+		return nil, notFoundError("there is no thing #%d", id)
+	}
+
+	thing := NewThing()
+	thing.Id = row.Id
+	thing.Type = row.Type
+	thing.Name = row.Name
+	thing.Parent = row.Parent
+	thing.Creator = row.Creator
+	thing.Created = row.Created
+	thing.Owner = row.Owner
+	thing.Superuser = row.Superuser
+	thing.Wizard = row.Wizard
+	thing.AdminList = copyThingIdList(row.AdminList)
+	thing.AllowList = copyThingIdList(row.AllowList)
+	thing.DenyList = copyThingIdList(row.DenyList)
+	if row.program != nil {
+		thing.Program = LoadProgram(*row.program)
+	}
+
+	// Decode the table data fresh so callers never share maps with our stored copy.
+	table, err := DecodeTable(row.tabledata)
+	if err != nil {
+		log.Println("Error finding table data for thing", id, ":", err.Error())
+		return nil, &StoreError{StoreConstraintViolation, err}
+	}
+	thing.Table = table
+
+	// Find thing's contents.
+	if thing.Type.HasContents() {
+		for childId, child := range w.things {
+			if child.Parent == id {
+				thing.Contents = append(thing.Contents, childId)
+			}
+		}
+		sort.Sort(thingIdsById(thing.Contents))
+	}
+
+	return thing, nil
+}
+
+func (w *MemoryWorld) CreateThing(name string, tt ThingType, creator *Thing, parent *Thing) (*Thing, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	if _, ok := w.things[parent.Id]; !ok {
+		return nil, &StoreError{StoreConstraintViolation, fmt.Errorf("there is no thing #%d to create a thing in", parent.Id)}
+	}
+
+	row := w.newRow(name, tt)
+	row.Parent = parent.Id
+	if creator != nil && tt.HasOwner() {
+		row.Creator = creator.Id
+		row.Owner = creator.Id
+	}
+	w.things[row.Id] = row
+
+	thing := NewThing()
+	thing.Id = row.Id
+	thing.Name = row.Name
+	thing.Type = row.Type
+	thing.Parent = row.Parent
+	thing.Creator = row.Creator
+	thing.Owner = row.Owner
+	thing.Created = row.Created
+	return thing, nil
+}
+
+func (w *MemoryWorld) MoveThing(thing *Thing, target *Thing) error {
+	w.Lock()
+	defer w.Unlock()
+
+	row, ok := w.things[thing.Id]
+	if !ok {
+		return notFoundError("there is no thing #%d", thing.Id)
+	}
+	if _, ok := w.things[target.Id]; !ok {
+		return &StoreError{StoreConstraintViolation, fmt.Errorf("there is no thing #%d to move into", target.Id)}
+	}
+	row.Parent = target.Id
+	return nil
+}
+
+func (w *MemoryWorld) SaveThing(thing *Thing) error {
+	tabletext, err := EncodeTable(thing.Table)
+	if err != nil {
+		log.Println("Error serializing table data for thing", thing.Id, ":", err.Error())
+		return &StoreError{StoreConstraintViolation, err}
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	row, ok := w.things[thing.Id]
+	if !ok {
+		return notFoundError("there is no thing #%d", thing.Id)
+	}
+
+	row.Name = thing.Name
+	row.Parent = thing.Parent
+	if thing.Type.HasOwner() {
+		row.Owner = thing.Owner
+	}
+	row.AdminList = copyThingIdList(thing.AdminList)
+	row.AllowList = copyThingIdList(thing.AllowList)
+	row.DenyList = copyThingIdList(thing.DenyList)
+	row.Wizard = thing.Wizard
+	row.tabledata = tabletext
+	row.program = nil
+	if thing.Program != nil {
+		text := thing.Program.Text
+		row.program = &text
+	}
+	return nil
+}
+
+// SetSuperuser makes the thing a superuser or not. Saving a thing never changes that, so this is only for importing worlds.
+func (w *MemoryWorld) SetSuperuser(id ThingId, superuser bool) error {
+	w.Lock()
+	defer w.Unlock()
+
+	row, ok := w.things[id]
+	if !ok {
+		return notFoundError("there is no thing #%d", id)
+	}
+	row.Superuser = superuser
+	return nil
+}
+
+func (w *MemoryWorld) DestroyThing(thing *Thing) error {
+	w.Lock()
+	defer w.Unlock()
+
+	if _, ok := w.things[thing.Id]; !ok {
+		return notFoundError("there is no thing #%d", thing.Id)
+	}
+	for _, acc := range w.accounts {
+		if acc.Character == thing.Id {
+			log.Println("Refusing to destroy thing", thing.Id, "as it's the character of an account")
+			return &StoreError{StoreConstraintViolation, fmt.Errorf("%s is the character of an account", thing.Name)}
+		}
+	}
+
+	// Actions go with the thing, but its other contents are rescued.
+	gone := ThingIdList{thing.Id}
+	for id, row := range w.things {
+		if row.Parent == thing.Id && row.Type == ActionThing {
+			gone = append(gone, id)
+		}
+	}
+	for _, id := range gone[1:] {
+		delete(w.things, id)
+		w.removeRevisions(id)
+		w.removeTimers(id)
+		w.removeScriptErrors(id)
+	}
+
+	homeId := thing.RecycleHome()
+	for _, row := range w.things {
+		if row.Parent == thing.Id {
+			row.Parent = homeId
+		}
+
+		row.AdminList = row.AdminList.Without(thing.Id)
+		row.AllowList = row.AllowList.Without(thing.Id)
+		row.DenyList = row.DenyList.Without(thing.Id)
+		if row.Creator == thing.Id {
+			row.Creator = 0
+		}
+		if row.Owner == thing.Id {
+			row.Owner = 0
+		}
+
+		if table, err := DecodeTable(row.tabledata); err == nil {
+			removed := false
+			for _, id := range gone {
+				if RemoveThingRefs(table, id) {
+					removed = true
+				}
+			}
+			if removed {
+				if tabletext, err := EncodeTable(table); err == nil {
+					row.tabledata = tabletext
+				}
+			}
+		}
+	}
+
+	w.removeRevisions(thing.Id)
+	w.removeTimers(thing.Id)
+	w.removeScriptErrors(thing.Id)
+	for _, rev := range w.revisions {
+		if rev.Editor == thing.Id {
+			rev.Editor = 0
+		}
+	}
+	for _, scriptErr := range w.scriptErrors {
+		if scriptErr.Player == thing.Id {
+			scriptErr.Player = 0
+		}
+	}
+
+	delete(w.things, thing.Id)
+	return nil
+}
+
+// removeRevisions forgets the history of the thing with the given id. The world must be locked.
+func (w *MemoryWorld) removeRevisions(id ThingId) {
+	kept := w.revisions[:0]
+	for _, rev := range w.revisions {
+		if rev.Thing != id {
+			kept = append(kept, rev)
+		}
+	}
+	w.revisions = kept
+}
+
+// removeTimers forgets the timers of the thing with the given id. The world must be locked.
+func (w *MemoryWorld) removeTimers(id ThingId) {
+	for timerId, timer := range w.timers {
+		if timer.Thing == id {
+			delete(w.timers, timerId)
+		}
+	}
+}
+
+func (w *MemoryWorld) SaveTimer(timer *Timer) error {
+	w.Lock()
+	defer w.Unlock()
+
+	if _, ok := w.things[timer.Thing]; !ok {
+		return notFoundError("there is no thing #%d", timer.Thing)
+	}
+	stored := *timer
+	stored.program = nil
+	w.timers[timer.Id] = stored
+	return nil
+}
+
+func (w *MemoryWorld) DeleteTimer(id int64) error {
+	w.Lock()
+	defer w.Unlock()
+	delete(w.timers, id)
+	return nil
+}
+
+func (w *MemoryWorld) AllTimers() ([]*Timer, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	var timers []*Timer
+	for _, stored := range w.timers {
+		timer := stored
+		timers = append(timers, &timer)
+	}
+	return timers, nil
+}
+
+// removeScriptErrors forgets the error log of the thing with the given id. The world must be locked.
+func (w *MemoryWorld) removeScriptErrors(id ThingId) {
+	kept := w.scriptErrors[:0]
+	for _, scriptErr := range w.scriptErrors {
+		if scriptErr.Thing != id {
+			kept = append(kept, scriptErr)
+		}
+	}
+	w.scriptErrors = kept
+}
+
+func (w *MemoryWorld) SaveScriptError(scriptErr *ScriptError) error {
+	w.Lock()
+	defer w.Unlock()
+
+	if _, ok := w.things[scriptErr.Thing]; !ok {
+		return notFoundError("there is no thing #%d", scriptErr.Thing)
+	}
+	w.lastScriptError++
+	scriptErr.Id = w.lastScriptError
+	stored := *scriptErr
+	stored.Traceback = append([]string(nil), scriptErr.Traceback...)
+	w.scriptErrors = append(w.scriptErrors, &stored)
+
+	// Forget the thing's oldest errors, which come first.
+	count := 0
+	for _, kept := range w.scriptErrors {
+		if kept.Thing == scriptErr.Thing {
+			count++
+		}
+	}
+	kept := w.scriptErrors[:0]
+	for _, stored := range w.scriptErrors {
+		if stored.Thing == scriptErr.Thing && count > MaxScriptErrors {
+			count--
+			continue
+		}
+		kept = append(kept, stored)
+	}
+	w.scriptErrors = kept
+	return nil
+}
+
+// ScriptErrors finds the error log of the thing with the given id, newest first.
+func (w *MemoryWorld) ScriptErrors(id ThingId) ([]*ScriptError, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	var scriptErrs []*ScriptError
+	for i := len(w.scriptErrors) - 1; i >= 0; i-- {
+		if stored := w.scriptErrors[i]; stored.Thing == id {
+			scriptErr := *stored
+			scriptErr.Traceback = append([]string(nil), stored.Traceback...)
+			scriptErrs = append(scriptErrs, &scriptErr)
+		}
+	}
+	return scriptErrs, nil
+}
+
+func (w *MemoryWorld) ClearScriptErrors(id ThingId) error {
+	w.Lock()
+	defer w.Unlock()
+	w.removeScriptErrors(id)
+	return nil
+}
+
+func (w *MemoryWorld) SaveRevisions(revs []*ThingRevision) error {
+	w.Lock()
+	defer w.Unlock()
+
+	// Check them all first, so either all or none of them are saved.
+	stored := make([]*memoryRevision, len(revs))
+	for i, rev := range revs {
+		if _, ok := w.things[rev.Thing]; !ok {
+			return &StoreError{StoreConstraintViolation, fmt.Errorf("there is no thing #%d to save a revision of", rev.Thing)}
+		}
+		tabletext, err := EncodeTable(rev.Table)
+		if err != nil {
+			log.Println("Error serializing table data for revision of thing", rev.Thing, ":", err.Error())
+			return &StoreError{StoreConstraintViolation, err}
+		}
+		stored[i] = &memoryRevision{*rev, tabletext}
+		stored[i].Table = nil
+		stored[i].AdminList = copyThingIdList(rev.AdminList)
+		stored[i].AllowList = copyThingIdList(rev.AllowList)
+		stored[i].DenyList = copyThingIdList(rev.DenyList)
+	}
+
+	for i, rev := range revs {
+		w.lastRevisionId++
+		rev.Id = w.lastRevisionId
+		stored[i].Id = rev.Id
+		w.revisions = append(w.revisions, stored[i])
+	}
+
+	// Forget all but the newest MaxThingRevisions of each thing's revisions.
+	counts := make(map[ThingId]int)
+	kept := make([]*memoryRevision, 0, len(w.revisions))
+	for i := len(w.revisions) - 1; 0 <= i; i-- {
+		rev := w.revisions[i]
+		counts[rev.Thing]++
+		if counts[rev.Thing] <= MaxThingRevisions {
+			kept = append(kept, rev)
+		}
+	}
+	w.revisions = w.revisions[:0]
+	for i := len(kept) - 1; 0 <= i; i-- {
+		w.revisions = append(w.revisions, kept[i])
+	}
+	return nil
+}
+
+func (w *MemoryWorld) ThingRevisions(id ThingId) ([]*ThingRevision, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	var revs []*ThingRevision
+	for i := len(w.revisions) - 1; 0 <= i; i-- {
+		stored := w.revisions[i]
+		if stored.Thing != id {
+			continue
+		}
+
+		rev := &ThingRevision{}
+		*rev = stored.ThingRevision
+		rev.AdminList = copyThingIdList(stored.AdminList)
+		rev.AllowList = copyThingIdList(stored.AllowList)
+		rev.DenyList = copyThingIdList(stored.DenyList)
+		table, err := DecodeTable(stored.tabledata)
+		if err != nil {
+			log.Println("Error finding table data for revision", rev.Id, ":", err.Error())
+			return nil, &StoreError{StoreConstraintViolation, err}
+		}
+		rev.Table = table
+		revs = append(revs, rev)
+	}
+	return revs, nil
+}
+
+func (w *MemoryWorld) AllThingIds() ([]ThingId, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	ids := make([]ThingId, 0, len(w.things))
+	for id := range w.things {
+		ids = append(ids, id)
+	}
+	sort.Sort(thingIdsById(ids))
+	return ids, nil
+}
+
+func (w *MemoryWorld) FindThings(q *ThingQuery) ([]ThingId, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	ids := make([]ThingId, 0, len(w.things))
+	for id := range w.things {
+		ids = append(ids, id)
+	}
+	sort.Sort(thingIdsById(ids))
+
+	var found []ThingId
+	for _, id := range ids {
+		row := w.things[id]
+		thing := row.Thing
+		table, err := DecodeTable(row.tabledata)
+		if err != nil {
+			log.Println("Error finding table data for thing", id, ":", err.Error())
+			return nil, &StoreError{StoreConstraintViolation, err}
+		}
+		thing.Table = table
+
+		if q.Matches(&thing) {
+			found = append(found, id)
+			if len(found) >= q.ResultLimit() {
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+func (w *MemoryWorld) GetAccount(name string) (*Account, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	stored, ok := w.accounts[name]
+	if !ok {
+		return nil, notFoundError("there is no account named %s", name)
+	}
+
+	// Like the database, don't hand out password hashes unless logging in.
+	acc := &Account{
+		LoginName: stored.LoginName,
+		Character: stored.Character,
+		Created:   stored.Created,
+	}
+	return acc, nil
+}
+
+func (w *MemoryWorld) AccountForLogin(name, password string) (*Account, error) {
+	w.Lock()
+	stored, ok := w.accounts[name]
+	w.Unlock()
+	if !ok {
+		log.Println("Error loading account with name", name, ": no such account in memory")
+		return nil, errBadLogin
+	}
+
+	if !bcrypt.Match(password, stored.PasswordHash) {
+		log.Println("Bad login attempt for account", name)
+		return nil, errBadLogin
+	}
+
+	acc := &Account{}
+	*acc = *stored
+	return acc, nil
+}
+
+func (w *MemoryWorld) CreateAccount(name, passwordHash string) (*Account, error) {
+	errDuplicate := &StoreError{StoreDuplicateLogin, errors.New("an account with that name already exists")}
+
+	w.Lock()
+	_, exists := w.accounts[name]
+	w.Unlock()
+	if exists {
+		return nil, errDuplicate
+	}
+
+	// TODO: Config setting for where to start new players?
+	origin, err := World.ThingForId(1)
+	if err != nil {
+		log.Println("Couldn't find starting place to create an account:", err.Error())
+		return nil, err
+	}
+	char, err := World.CreateThing(name, PlayerThing, nil, origin)
+	if err != nil {
+		log.Println("Couldn't create character to create an account:", err.Error())
+		return nil, err
+	}
+
+	w.Lock()
+	// Someone may have taken the name while we were making the character.
+	if _, exists := w.accounts[name]; exists {
+		w.Unlock()
+		World.DestroyThing(char)
+		return nil, errDuplicate
+	}
+
+	acc := &Account{name, passwordHash, char.Id, time.Now().UTC()}
+	stored := &Account{}
+	*stored = *acc
+	w.accounts[name] = stored
+	w.Unlock()
+
+	return acc, nil
+}
+
+func (w *MemoryWorld) AllAccounts() ([]*Account, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	accounts := make([]*Account, 0, len(w.accounts))
+	for _, stored := range w.accounts {
+		accounts = append(accounts, &Account{
+			LoginName: stored.LoginName,
+			Character: stored.Character,
+			Created:   stored.Created,
+		})
+	}
+	return accounts, nil
+}
+
+func (w *MemoryWorld) ImportAccount(name, password string, character ThingId) (*Account, error) {
+	passwordHash, err := bcrypt.Hash(password)
+	if err != nil {
+		log.Println("Couldn't hash password to import an account:", err.Error())
+		return nil, &StoreError{StoreUnavailable, err}
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if _, exists := w.accounts[name]; exists {
+		return nil, &StoreError{StoreDuplicateLogin, errors.New("an account with that name already exists")}
+	}
+	if _, ok := w.things[character]; !ok {
+		return nil, &StoreError{StoreConstraintViolation, fmt.Errorf("there is no thing #%d to be the account's character", character)}
+	}
+
+	acc := &Account{name, passwordHash, character, time.Now().UTC()}
+	stored := &Account{}
+	*stored = *acc
+	w.accounts[name] = stored
+	return acc, nil
+}
+
+// DeleteAccount removes the named account, leaving its character. It's for undoing an import that failed.
+func (w *MemoryWorld) DeleteAccount(name string) error {
+	w.Lock()
+	defer w.Unlock()
+	delete(w.accounts, name)
+	return nil
+}
+
+type thingIdsById ThingIdList
+
+func (l thingIdsById) Len() int           { return len(l) }
+func (l thingIdsById) Less(i, j int) bool { return l[i] < l[j] }
+func (l thingIdsById) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
@@ -0,0 +1,237 @@
+package mess
+
+import (
+	"testing"
+)
+
+// useMemoryWorld makes a new MemoryWorld for the test to use as World & Accounts. Call the returned func to put back the old ones.
+func useMemoryWorld() (*MemoryWorld, func()) {
+	mem := NewMemoryWorld()
+	oldWorld, oldAccounts := World, Accounts
+	World, Accounts = mem, mem
+	return mem, func() {
+		World, Accounts = oldWorld, oldAccounts
+	}
+}
+
+func mustCreate(t *testing.T, name string, tt ThingType, creator *Thing, parent *Thing) *Thing {
+	thing, err := World.CreateThing(name, tt, creator, parent)
+	if err != nil {
+		t.Fatalf("couldn't create %s: %s", name, err.Error())
+	}
+	return thing
+}
+
+func mustLoad(t *testing.T, id ThingId) *Thing {
+	thing, err := World.ThingForId(id)
+	if err != nil {
+		t.Fatalf("couldn't load #%d: %s", id, err.Error())
+	}
+	return thing
+}
+
+func sameIds(a, b ThingIdList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryWorldIds(t *testing.T) {
+	mem, restore := useMemoryWorld()
+	defer restore()
+
+	origin := mustLoad(t, 1)
+	if origin.Type != PlaceThing || origin.Parent != 0 {
+		t.Fatalf("a new world's #1 should be its first place, not a %s in #%d", origin.Type, origin.Parent)
+	}
+
+	box := mustCreate(t, "Box", RegularThing, nil, origin)
+	lamp := mustCreate(t, "Lamp", RegularThing, nil, origin)
+	if box.Id != 2 || lamp.Id != 3 {
+		t.Errorf("new things got ids #%d & #%d, not #2 & #3", box.Id, lamp.Id)
+	}
+
+	if err := mem.DestroyThing(lamp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.ThingForId(lamp.Id); StoreFailureOf(err) != StoreNotFound {
+		t.Errorf("loading a destroyed thing should fail as not found, not with %v", err)
+	}
+	if candle := mustCreate(t, "Candle", RegularThing, nil, origin); candle.Id != 4 {
+		t.Errorf("a thing made after #3 was destroyed got id #%d, not #4", candle.Id)
+	}
+
+	if _, err := mem.ThingForId(99); StoreFailureOf(err) != StoreNotFound {
+		t.Errorf("loading a thing that never existed should fail as not found, not with %v", err)
+	}
+}
+
+func TestMemoryWorldContents(t *testing.T) {
+	mem, restore := useMemoryWorld()
+	defer restore()
+
+	origin := mustLoad(t, 1)
+	box := mustCreate(t, "Box", RegularThing, nil, origin)
+	lamp := mustCreate(t, "Lamp", RegularThing, nil, box)
+	exit := mustCreate(t, "open", ActionThing, nil, box)
+
+	if contents := mustLoad(t, 1).Contents; !sameIds(contents, ThingIdList{box.Id}) {
+		t.Errorf("the first place contains %v, not the box", contents)
+	}
+	if contents := mustLoad(t, box.Id).Contents; !sameIds(contents, ThingIdList{lamp.Id, exit.Id}) {
+		t.Errorf("the box contains %v, not the lamp & its action", contents)
+	}
+
+	if err := mem.MoveThing(lamp, origin); err != nil {
+		t.Fatal(err)
+	}
+	if contents := mustLoad(t, 1).Contents; !sameIds(contents, ThingIdList{box.Id, lamp.Id}) {
+		t.Errorf("after moving the lamp out, the first place contains %v", contents)
+	}
+	if contents := mustLoad(t, box.Id).Contents; !sameIds(contents, ThingIdList{exit.Id}) {
+		t.Errorf("after moving the lamp out, the box contains %v", contents)
+	}
+
+	// Destroying the box rescues its contents into its parent, but its actions go with it, and references to them too.
+	if err := mem.MoveThing(lamp, box); err != nil {
+		t.Fatal(err)
+	}
+	inBox := mustLoad(t, lamp.Id)
+	inBox.Table["exit"] = exit.Id
+	if err := mem.SaveThing(inBox); err != nil {
+		t.Fatal(err)
+	}
+	// Older actions' targets are plain numbers.
+	enter := mustCreate(t, "enter box", ActionThing, nil, inBox)
+	enter.Table["target"] = float64(box.Id)
+	if err := mem.SaveThing(enter); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.DestroyThing(mustLoad(t, box.Id)); err != nil {
+		t.Fatal(err)
+	}
+	if contents := mustLoad(t, 1).Contents; !sameIds(contents, ThingIdList{lamp.Id}) {
+		t.Errorf("after destroying the box, the first place contains %v, not the lamp", contents)
+	}
+	if saved := mustLoad(t, lamp.Id); saved.Parent != origin.Id {
+		t.Errorf("the lamp wasn't rescued into the first place")
+	} else if _, ok := saved.Table["exit"]; ok {
+		t.Errorf("the lamp still refers to the box's destroyed action")
+	}
+	if target, ok := mustLoad(t, enter.Id).Table["target"]; ok {
+		t.Errorf("the exit into the box still has the target %v", target)
+	}
+	if _, err := mem.ThingForId(exit.Id); StoreFailureOf(err) != StoreNotFound {
+		t.Errorf("the box's action should be destroyed with it, but loading it got %v", err)
+	}
+}
+
+// TestActiveWorldContents checks that the ActiveWorld keeps the contents of the things it has in memory up to date as things are created, moved & destroyed, the same as the MemoryWorld behind it.
+func TestActiveWorldContents(t *testing.T) {
+	active, mem, restore := useActiveWorld(0)
+	defer restore()
+
+	origin := mustLoad(t, 1)
+	box := mustCreate(t, "Box", RegularThing, nil, origin)
+	lamp := mustCreate(t, "Lamp", RegularThing, nil, origin)
+	if !sameIds(origin.Contents, ThingIdList{box.Id, lamp.Id}) {
+		t.Errorf("after creating things in it, the first place contains %v", origin.Contents)
+	}
+
+	if err := lamp.MoveTo(box); err != nil {
+		t.Fatal(err)
+	}
+	if !sameIds(origin.Contents, ThingIdList{box.Id}) || !sameIds(box.Contents, ThingIdList{lamp.Id}) {
+		t.Errorf("after moving the lamp into the box, the first place contains %v & the box %v", origin.Contents, box.Contents)
+	}
+
+	if err := active.DestroyThing(box); err != nil {
+		t.Fatal(err)
+	}
+	if !sameIds(origin.Contents, ThingIdList{lamp.Id}) || lamp.Parent != origin.Id {
+		t.Errorf("after destroying the box, the first place contains %v & the lamp is in #%d", origin.Contents, lamp.Parent)
+	}
+
+	for _, thing := range []*Thing{origin, lamp} {
+		saved, err := mem.ThingForId(thing.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Parent != thing.Parent || !sameIds(saved.Contents, thing.Contents) {
+			t.Errorf("%s is in #%d containing %v in memory, but #%d containing %v in the store", thing.Name, thing.Parent, thing.Contents, saved.Parent, saved.Contents)
+		}
+	}
+}
+
+func TestMemoryWorldAccounts(t *testing.T) {
+	mem, restore := useMemoryWorld()
+	defer restore()
+
+	passwordHash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := mem.CreateAccount("alice", passwordHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	char := mustLoad(t, account.Character)
+	if char.Type != PlayerThing || char.Name != "alice" || char.Parent != 1 {
+		t.Errorf("the new account's character is a %s named %q in #%d", char.Type, char.Name, char.Parent)
+	}
+
+	if _, err := mem.CreateAccount("alice", passwordHash); StoreFailureOf(err) != StoreDuplicateLogin {
+		t.Errorf("creating an account with a taken name should fail as a duplicate login, not with %v", err)
+	}
+	if ids, _ := mem.AllThingIds(); len(ids) != 2 {
+		t.Errorf("the duplicate account left a character behind: the world has things %v", ids)
+	}
+
+	if acc, err := mem.AccountForLogin("alice", "hunter2"); err != nil || acc.Character != account.Character {
+		t.Errorf("logging in with the right password got %v, %v", acc, err)
+	}
+	if _, err := mem.AccountForLogin("alice", "hunter3"); StoreFailureOf(err) != StoreNotFound {
+		t.Errorf("logging in with the wrong password should fail as not found, not with %v", err)
+	}
+	if _, err := mem.AccountForLogin("bob", "hunter2"); StoreFailureOf(err) != StoreNotFound {
+		t.Errorf("logging in with an unknown name should fail as not found, not with %v", err)
+	}
+
+	if err := mem.DestroyThing(char); StoreFailureOf(err) != StoreConstraintViolation {
+		t.Errorf("destroying an account's character should be refused, not get %v", err)
+	}
+}
+
+func TestMemoryWorldKeepsNewestRevisions(t *testing.T) {
+	mem, restore := useMemoryWorld()
+	defer restore()
+
+	lamp := mustCreate(t, "lamp", RegularThing, nil, mustLoad(t, 1))
+	for i := 0; i < MaxThingRevisions+5; i++ {
+		lamp.Table["turns"] = float64(i)
+		rev, err := NewRevision(lamp, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := mem.SaveRevisions([]*ThingRevision{rev}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revs, err := mem.ThingRevisions(lamp.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != MaxThingRevisions {
+		t.Fatalf("after saving %d revisions, %d were kept, not %d", MaxThingRevisions+5, len(revs), MaxThingRevisions)
+	}
+	if turns := revs[0].Table["turns"]; turns != float64(MaxThingRevisions+4) {
+		t.Errorf("the newest revision kept is from turn %v", turns)
+	}
+}
@@ -0,0 +1,174 @@
+package mess
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migration is one numbered step in building up a mess's database schema.
+type Migration struct {
+	Version int
+	Name    string
+	Script  string
+}
+
+// Migrations are the schema migrations for the configured database driver, in version order. The mess command loads them from its built-in assets before starting the server.
+var Migrations []Migration
+
+type migrationsByVersion []Migration
+
+func (m migrationsByVersion) Len() int           { return len(m) }
+func (m migrationsByVersion) Less(i, j int) bool { return m[i].Version < m[j].Version }
+func (m migrationsByVersion) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// MigrationsDir is the asset directory holding the migrations for the named database driver.
+func MigrationsDir(driver string) string {
+	if driver == "" {
+		driver = "postgres"
+	}
+	return path.Join("migrations", driver)
+}
+
+// LoadMigrations finds the migrations for the named database driver among the given asset names. Migration assets are named like "migrations/postgres/0002_add_things.sql", and must be numbered from 1 with no gaps.
+func LoadMigrations(driver string, names []string, asset func(string) ([]byte, error)) ([]Migration, error) {
+	dir := MigrationsDir(driver)
+	var migrations []Migration
+	for _, name := range names {
+		if path.Dir(name) != dir || path.Ext(name) != ".sql" {
+			continue
+		}
+
+		base := strings.TrimSuffix(path.Base(name), ".sql")
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s is not numbered: %s", name, err.Error())
+		}
+
+		script, err := asset(name)
+		if err != nil {
+			return nil, err
+		}
+
+		migration := Migration{
+			Version: version,
+			Name:    base,
+			Script:  string(script),
+		}
+		migrations = append(migrations, migration)
+	}
+
+	sort.Sort(migrationsByVersion(migrations))
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("expected migration #%d in %s but found %s", i+1, dir, migration.Name)
+		}
+	}
+
+	return migrations, nil
+}
+
+// SchemaVersion finds the version of the latest migration applied to db. Databases created from mess.sql before there were migrations count as version 1.
+func SchemaVersion(db *sql.DB) (version int, err error) {
+	var maxVersion sql.NullInt64
+	row := db.QueryRow("SELECT MAX(version) FROM schema_version")
+	err = row.Scan(&maxVersion)
+	if err == nil {
+		return int(maxVersion.Int64), nil
+	}
+
+	// Maybe there's no schema_version table yet. Make sure we're connected before deciding that.
+	err = db.Ping()
+	if err != nil {
+		return 0, err
+	}
+
+	var numThings int
+	row = db.QueryRow("SELECT COUNT(*) FROM thing")
+	if row.Scan(&numThings) == nil {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Migrate applies the migrations db hasn't had yet, each in its own transaction.
+func Migrate(db *sql.DB, migrations []Migration) error {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+    version INTEGER NOT NULL PRIMARY KEY,
+    applied TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`)
+	if err != nil {
+		return err
+	}
+	if version == 1 {
+		// Record the version of a database made before there were migrations. (Recording it again is harmless if it's already there.)
+		_, err = db.Exec("INSERT INTO schema_version (version) SELECT 1 WHERE NOT EXISTS (SELECT 1 FROM schema_version)")
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(migrations) < version {
+		return fmt.Errorf("database schema is at version %d, newer than this mess knows about (%d)", version, len(migrations))
+	}
+
+	for _, migration := range migrations[version:] {
+		log.Println("Applying database migration", migration.Name)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(migration.Script)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s failed: %s", migration.Name, err.Error())
+		}
+
+		_, err = tx.Exec(fmt.Sprintf("INSERT INTO schema_version (version) VALUES (%d)", migration.Version))
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s failed: %s", migration.Name, err.Error())
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return fmt.Errorf("migration %s failed: %s", migration.Name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// CheckSchema returns an error if db's schema isn't the one created by the given migrations.
+func CheckSchema(db *sql.DB, migrations []Migration) error {
+	if len(migrations) == 0 {
+		log.Println("No database migrations were loaded, so not checking the database schema version")
+		return nil
+	}
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	latest := len(migrations)
+	if version < latest {
+		return fmt.Errorf("database schema is at version %d but this mess needs version %d; run `mess --migrate` to update it", version, latest)
+	}
+	if version > latest {
+		return fmt.Errorf("database schema is at version %d, newer than this mess knows about (%d)", version, latest)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+CREATE TABLE thing_revision (
+    id SERIAL PRIMARY KEY,
+    thing INTEGER NOT NULL REFERENCES thing,
+    editor INTEGER REFERENCES thing,
+    created TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    name TEXT NOT NULL,
+    owner INTEGER,
+    adminlist INTEGER[] NOT NULL DEFAULT ARRAY[]::integer[],
+    allowlist INTEGER[] NOT NULL DEFAULT ARRAY[]::integer[],
+    denylist INTEGER[] NOT NULL DEFAULT ARRAY[]::integer[],
+    tabledata JSON NOT NULL DEFAULT '{}'::json,
+    program TEXT
+);
+
+CREATE INDEX thing_revision_thing ON thing_revision (thing, id);
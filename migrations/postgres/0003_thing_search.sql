@@ -0,0 +1,11 @@
+-- Keep table data as jsonb, so it can be searched by key & value with an index.
+ALTER TABLE thing ALTER COLUMN tabledata DROP DEFAULT;
+ALTER TABLE thing ALTER COLUMN tabledata TYPE JSONB USING tabledata::jsonb;
+ALTER TABLE thing ALTER COLUMN tabledata SET DEFAULT '{}'::jsonb;
+
+CREATE INDEX thing_name ON thing (lower(name) text_pattern_ops);
+CREATE INDEX thing_type ON thing (type);
+CREATE INDEX thing_owner ON thing (owner);
+CREATE INDEX thing_creator ON thing (creator);
+CREATE INDEX thing_parent ON thing (parent);
+CREATE INDEX thing_tabledata ON thing USING GIN (tabledata);
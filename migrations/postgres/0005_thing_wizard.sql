@@ -0,0 +1 @@
+ALTER TABLE thing ADD COLUMN wizard BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,12 @@
+CREATE TABLE script_error (
+    id BIGSERIAL PRIMARY KEY,
+    thing INTEGER NOT NULL REFERENCES thing,
+    handler TEXT NOT NULL DEFAULT '',
+    line INTEGER NOT NULL DEFAULT 0,
+    message TEXT NOT NULL,
+    traceback TEXT NOT NULL DEFAULT '',
+    player INTEGER,
+    created TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+CREATE INDEX script_error_thing ON script_error (thing, id);
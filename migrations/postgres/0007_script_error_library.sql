@@ -0,0 +1 @@
+ALTER TABLE script_error ADD COLUMN library INTEGER NOT NULL DEFAULT 0;
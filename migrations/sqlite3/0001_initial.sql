@@ -0,0 +1,26 @@
+CREATE TABLE thing (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    type TEXT NOT NULL DEFAULT 'thing' CHECK (type IN ('thing', 'place', 'player', 'action', 'program')),
+    name TEXT NOT NULL,
+    creator INTEGER REFERENCES thing DEFERRABLE INITIALLY DEFERRED,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    owner INTEGER REFERENCES thing DEFERRABLE INITIALLY DEFERRED,
+    superuser BOOLEAN NOT NULL DEFAULT 0,
+    adminlist TEXT NOT NULL DEFAULT '[]',
+    allowlist TEXT NOT NULL DEFAULT '[]',
+    denylist TEXT NOT NULL DEFAULT '[]',
+    parent INTEGER REFERENCES thing,
+    tabledata TEXT NOT NULL DEFAULT '{}',
+    program TEXT
+);
+
+CREATE INDEX thing_parent ON thing (parent);
+
+INSERT INTO thing(type, name) VALUES ('place', 'Room One');
+
+CREATE TABLE account (
+    loginname TEXT NOT NULL PRIMARY KEY,
+    passwordhash TEXT NOT NULL,
+    character INTEGER NOT NULL REFERENCES thing,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
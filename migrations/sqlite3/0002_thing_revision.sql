@@ -0,0 +1,15 @@
+CREATE TABLE thing_revision (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    thing INTEGER NOT NULL REFERENCES thing,
+    editor INTEGER REFERENCES thing,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    name TEXT NOT NULL,
+    owner INTEGER,
+    adminlist TEXT NOT NULL DEFAULT '[]',
+    allowlist TEXT NOT NULL DEFAULT '[]',
+    denylist TEXT NOT NULL DEFAULT '[]',
+    tabledata TEXT NOT NULL DEFAULT '{}',
+    program TEXT
+);
+
+CREATE INDEX thing_revision_thing ON thing_revision (thing, id);
@@ -0,0 +1,4 @@
+CREATE INDEX thing_name ON thing (lower(name));
+CREATE INDEX thing_type ON thing (type);
+CREATE INDEX thing_owner ON thing (owner);
+CREATE INDEX thing_creator ON thing (creator);
@@ -0,0 +1,10 @@
+CREATE TABLE timer (
+    id INTEGER PRIMARY KEY,
+    thing INTEGER NOT NULL REFERENCES thing,
+    owner INTEGER,
+    callback TEXT NOT NULL,
+    due TIMESTAMP NOT NULL,
+    interval_ms INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX timer_thing ON timer (thing);
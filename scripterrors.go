@@ -0,0 +1,186 @@
+package mess
+
+import (
+	"fmt"
+	"github.com/aarzilli/golua/lua"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxScriptErrors is how many of a program's errors are kept in its error log. Older ones are forgotten as new ones are saved.
+const MaxScriptErrors = 20
+
+// ScriptErrorPeriod is how often each program's errors can be saved & reported: an error just like the last one saved for the thing isn't saved again until the period is up, and only MaxScriptErrors of its errors are saved per period. That keeps a program failing over & over, as in a timer, from filling the world with writes.
+const ScriptErrorPeriod = time.Minute
+
+// ScriptError is an error in a thing's program, with where in the program it happened & who it happened to.
+type ScriptError struct {
+	Id int64
+	// Thing is the id of the thing whose program failed.
+	Thing ThingId
+	// Function is the name of the function the program was called for, or "" if the program failed to load.
+	Function string
+	// Line is the line of the program where the error happened, or 0 if it isn't known. If the error happened in a library, it's the line of the program that called into the library.
+	Line int
+	// Library is the id of the library the error happened in, or 0 if it happened in the program itself.
+	Library ThingId
+	Message string
+	// Traceback is the calls in progress when the error happened, innermost first.
+	Traceback []string
+	// Player is the player acting when the error happened, such as the one whose move called Entered, or 0 if it isn't known.
+	Player  ThingId
+	Created time.Time
+}
+
+func (e *ScriptError) Error() string {
+	if e.Line != 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return e.Message
+}
+
+// Where describes the part of the program that failed, as "Entered" or "loading the program".
+func (e *ScriptError) Where() string {
+	if e.Function == "" {
+		return "loading the program"
+	}
+	return e.Function
+}
+
+func (e *ScriptError) GetPlayer() *Thing {
+	if e.Player == 0 {
+		return nil
+	}
+	return GetThing(e.Player)
+}
+
+func (e *ScriptError) GetLibrary() *Thing {
+	if e.Library == 0 {
+		return nil
+	}
+	return GetThing(e.Library)
+}
+
+// scriptErrorPosition matches the position Lua puts at the start of error messages from a chunk loaded from a string, as `[string "function Entered()..."]:12: `, with the chunk's name & line.
+var scriptErrorPosition = regexp.MustCompile(`^(\[string "[^\n]*?"\]):(\d+): `)
+
+// libraryChunk matches the source or name of a library's chunk, which starts with libraryChunkPrefix, with the library's id.
+var libraryChunk = regexp.MustCompile(`^(?:\[string ")?--\[\[library #(\d+)\]\] `)
+
+// chunkName is the name Lua gives a chunk loaded from the text as a string in its error messages & stack traces, which is the start of its first line (see luaO_chunkid).
+func chunkName(text string) string {
+	const maxLength = 60 - len(` [string "..."] `) - 1
+	length := strings.IndexAny(text, "\r\n")
+	if length == -1 {
+		length = len(text)
+	}
+	if length > maxLength {
+		length = maxLength
+	}
+	if length < len(text) {
+		return fmt.Sprintf(`[string "%s..."]`, text[:length])
+	}
+	return fmt.Sprintf(`[string "%s"]`, text)
+}
+
+// libraryOfChunk is the id of the library the chunk with the given source or name is, or 0 if it isn't one.
+func libraryOfChunk(source string) ThingId {
+	match := libraryChunk.FindStringSubmatch(source)
+	if match == nil {
+		return 0
+	}
+	id, _ := strconv.ParseInt(match[1], 10, 64)
+	return ThingId(id)
+}
+
+// NewScriptError makes a ScriptError from an error calling the program's function (or loading the program, if function is ""). Its line is found in its message or its Lua stack trace, counting only lines of the program's own text, so an error in a library records the library instead.
+func NewScriptError(err error, function string, text string) *ScriptError {
+	if scriptErr, ok := err.(*ScriptError); ok {
+		return scriptErr
+	}
+
+	scriptErr := &ScriptError{
+		Function: function,
+		Message:  err.Error(),
+	}
+	if match := scriptErrorPosition.FindStringSubmatch(scriptErr.Message); match != nil {
+		line, _ := strconv.Atoi(match[2])
+		scriptErr.Message = scriptErr.Message[len(match[0]):]
+		if match[1] == chunkName(text) {
+			scriptErr.Line = line
+		} else if library := libraryOfChunk(match[1]); library != 0 {
+			scriptErr.Library = library
+			scriptErr.Message = fmt.Sprintf("in library #%d at line %d: %s", library, line, scriptErr.Message)
+		}
+	}
+
+	if luaErr, ok := err.(*lua.LuaError); ok {
+		for _, entry := range luaErr.StackTrace() {
+			name := entry.Name
+			if name == "" {
+				name = "?"
+			}
+			source := entry.ShortSource
+			if library := libraryOfChunk(entry.Source); library != 0 {
+				source = fmt.Sprintf("library #%d", library)
+			}
+			scriptErr.Traceback = append(scriptErr.Traceback, fmt.Sprintf("%s:%d: in %s", source, entry.CurrentLine, name))
+			if scriptErr.Line == 0 && entry.CurrentLine > 0 && entry.Source == text {
+				scriptErr.Line = entry.CurrentLine
+			}
+		}
+	}
+	return scriptErr
+}
+
+// sameAs reports whether the errors happened in the same place for the same reason, if not for the same player or at the same time.
+func (e *ScriptError) sameAs(other *ScriptError) bool {
+	return e.Thing == other.Thing && e.Function == other.Function && e.Line == other.Line && e.Library == other.Library && e.Message == other.Message
+}
+
+// recentScriptErrors is what was saved of each thing's errors in its current ScriptErrorPeriod.
+var recentScriptErrors = struct {
+	sync.Mutex
+	things map[ThingId]*recentScriptError
+}{things: make(map[ThingId]*recentScriptError)}
+
+type recentScriptError struct {
+	since time.Time
+	last  *ScriptError
+	saved int
+}
+
+// noteScriptError reports whether the error should be saved to its thing's error log & reported, noting it if so. It shouldn't if it's just like the last error saved for the thing, or the thing has had too many errors saved already, in the current ScriptErrorPeriod.
+func noteScriptError(scriptErr *ScriptError) bool {
+	recentScriptErrors.Lock()
+	defer recentScriptErrors.Unlock()
+
+	recent, ok := recentScriptErrors.things[scriptErr.Thing]
+	if !ok || scriptErr.Created.Sub(recent.since) >= ScriptErrorPeriod {
+		// Forget the things whose periods are up, so things that failed once aren't remembered forever.
+		for id, other := range recentScriptErrors.things {
+			if scriptErr.Created.Sub(other.since) >= ScriptErrorPeriod {
+				delete(recentScriptErrors.things, id)
+			}
+		}
+		recent = &recentScriptError{since: scriptErr.Created}
+		recentScriptErrors.things[scriptErr.Thing] = recent
+	}
+
+	if recent.last != nil && recent.last.sameAs(scriptErr) || recent.saved >= MaxScriptErrors {
+		return false
+	}
+	recent.last = scriptErr
+	recent.saved++
+	return true
+}
+
+// forgetScriptErrors forgets the thing's recent errors, so errors in its new program are saved right away.
+func forgetScriptErrors(id ThingId) {
+	recentScriptErrors.Lock()
+	defer recentScriptErrors.Unlock()
+	delete(recentScriptErrors.things, id)
+}
@@ -0,0 +1,70 @@
+package mess
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewScriptErrorLines(t *testing.T) {
+	text := "function Entered()\n  error('oops')\nend"
+	longText := strings.Repeat("x", 100) + "\nfunction Run() end"
+	library := fmt.Sprintf(libraryChunkPrefix, 12) + "local strings = {}\nreturn strings"
+
+	for _, test := range []struct {
+		text, message string
+		line          int
+		library       ThingId
+	}{
+		{text, chunkName(text) + ":2: oops", 2, 0},
+		{longText, chunkName(longText) + ":7: oops", 7, 0},
+		{text, chunkName(library) + ":2: oops", 0, 12},
+		{text, `[string "return 1 + nil"]:1: oops`, 0, 0},
+	} {
+		scriptErr := NewScriptError(errors.New(test.message), "Entered", test.text)
+		if scriptErr.Line != test.line || scriptErr.Library != test.library {
+			t.Errorf("the error %q was at line %d of library #%d, not line %d of library #%d", test.message, scriptErr.Line, scriptErr.Library, test.line, test.library)
+		}
+		if !strings.HasSuffix(scriptErr.Message, "oops") || strings.Contains(scriptErr.Message, "[string") {
+			t.Errorf("the error %q has the message %q", test.message, scriptErr.Message)
+		}
+	}
+
+	if name := chunkName(longText); name != `[string "`+strings.Repeat("x", 43)+`..."]` {
+		t.Errorf("a long program's chunk is named %s", name)
+	}
+}
+
+func TestNoteScriptErrorCollapsesRepeats(t *testing.T) {
+	const thing = ThingId(9999)
+	defer forgetScriptErrors(thing)
+
+	start := time.Now()
+	at := func(offset time.Duration, message string) *ScriptError {
+		return &ScriptError{Thing: thing, Function: "Tick", Line: 3, Message: message, Created: start.Add(offset)}
+	}
+	if !noteScriptError(at(0, "oops")) {
+		t.Error("the first error wasn't saved")
+	}
+	if noteScriptError(at(time.Second, "oops")) {
+		t.Error("the same error a second later was saved again")
+	}
+	if !noteScriptError(at(2*time.Second, "other")) {
+		t.Error("a different error wasn't saved")
+	}
+	if !noteScriptError(at(ScriptErrorPeriod+time.Second, "other")) {
+		t.Error("the same error after the period was up wasn't saved")
+	}
+
+	saved := 1
+	for i := 0; i < 2*MaxScriptErrors; i++ {
+		if noteScriptError(at(ScriptErrorPeriod+2*time.Second, fmt.Sprintf("error %d", i))) {
+			saved++
+		}
+	}
+	if saved != MaxScriptErrors {
+		t.Errorf("%d different errors were saved in one period, not %d", saved, MaxScriptErrors)
+	}
+}
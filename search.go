@@ -0,0 +1,252 @@
+package mess
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultFindLimit is how many things a search finds if it doesn't ask for a number.
+	DefaultFindLimit = 20
+	// MaxFindLimit is the most things one search can find.
+	MaxFindLimit = 200
+)
+
+// ThingQuery describes the things to find with WorldStore.FindThings. Things must match all the fields that are set, and the zero ThingQuery matches everything.
+type ThingQuery struct {
+	// Name matches a thing's whole name, and NamePrefix & NameContains the start of or anywhere in it, ignoring case.
+	Name         string
+	NamePrefix   string
+	NameContains string
+
+	Type    ThingType
+	Owner   ThingId
+	Creator ThingId
+	Parent  ThingId
+
+	// TableKey matches things with that key in their table data. If TableValue is also set, the key's value must be equal to it: a string, float64, bool or ThingId.
+	TableKey   string
+	TableValue interface{}
+
+	// Limit is the most things to find. If it's 0, DefaultFindLimit things are found; it's never more than MaxFindLimit.
+	Limit int
+	// After matches only things with greater ids, for finding the next things after a search that found as many as it could. Things are found in order of their ids.
+	After ThingId
+}
+
+// ResultLimit is how many things the query can find.
+func (q *ThingQuery) ResultLimit() int {
+	if q.Limit <= 0 {
+		return DefaultFindLimit
+	}
+	if q.Limit > MaxFindLimit {
+		return MaxFindLimit
+	}
+	return q.Limit
+}
+
+// Check reports whether the query can be searched for. Table keys are limited so stores can safely look them up inside the table data.
+func (q *ThingQuery) Check() error {
+	if q.TableValue != nil && q.TableKey == "" {
+		return &StoreError{StoreConstraintViolation, errors.New("a table value can only be searched for with a table key")}
+	}
+	if strings.ContainsAny(q.TableKey, "\"\\") {
+		return &StoreError{StoreConstraintViolation, errors.New("table keys to search for can't contain quotes or backslashes")}
+	}
+	switch q.TableValue.(type) {
+	case nil, string, float64, bool, ThingId:
+	default:
+		return &StoreError{StoreConstraintViolation, fmt.Errorf("can't search for a table value like %v", q.TableValue)}
+	}
+	return nil
+}
+
+// Matches reports whether the thing fits the query, for stores that have to look at every thing.
+func (q *ThingQuery) Matches(thing *Thing) bool {
+	if thing.Id <= q.After {
+		return false
+	}
+	name := strings.ToLower(thing.Name)
+	if q.Name != "" && name != strings.ToLower(q.Name) {
+		return false
+	}
+	if q.NamePrefix != "" && !strings.HasPrefix(name, strings.ToLower(q.NamePrefix)) {
+		return false
+	}
+	if q.NameContains != "" && !strings.Contains(name, strings.ToLower(q.NameContains)) {
+		return false
+	}
+	if q.Type != "" && thing.Type != q.Type {
+		return false
+	}
+	if q.Owner != 0 && thing.Owner != q.Owner {
+		return false
+	}
+	if q.Creator != 0 && thing.Creator != q.Creator {
+		return false
+	}
+	if q.Parent != 0 && thing.Parent != q.Parent {
+		return false
+	}
+	if q.TableKey != "" {
+		value, ok := thing.Table[q.TableKey]
+		if !ok {
+			return false
+		}
+		if q.TableValue != nil && !tableValueEquals(value, q.TableValue) {
+			return false
+		}
+	}
+	return true
+}
+
+func tableValueEquals(value, other interface{}) bool {
+	switch v := value.(type) {
+	case string, float64, bool, ThingId:
+		return v == other
+	case int:
+		// Tables set from Go code may hold ints, while ones loaded from JSON hold float64s.
+		return float64(v) == other
+	}
+	return false
+}
+
+// likePattern makes a SQL LIKE pattern (with \ as the escape character) matching text, optionally with anything before it.
+func likePattern(text string, anyPrefix bool) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	pattern := replacer.Replace(strings.ToLower(text)) + "%"
+	if anyPrefix {
+		pattern = "%" + pattern
+	}
+	return pattern
+}
+
+// sqlSearch collects the conditions & arguments of a SQL query for things. Conditions are added with %s for their arguments, which placeholder turns into the database's parameter syntax.
+type sqlSearch struct {
+	conditions  []string
+	args        []interface{}
+	placeholder func(n int) string
+}
+
+func (s *sqlSearch) add(condition string, args ...interface{}) {
+	marks := make([]interface{}, len(args))
+	for i, arg := range args {
+		s.args = append(s.args, arg)
+		marks[i] = s.placeholder(len(s.args))
+	}
+	s.conditions = append(s.conditions, fmt.Sprintf(condition, marks...))
+}
+
+// addCommon adds the conditions that are the same in every SQL database.
+func (s *sqlSearch) addCommon(q *ThingQuery) {
+	if q.After != 0 {
+		s.add("id > %s", q.After)
+	}
+	if q.Name != "" {
+		s.add("lower(name) = %s", strings.ToLower(q.Name))
+	}
+	if q.NamePrefix != "" {
+		s.add(`lower(name) LIKE %s ESCAPE '\'`, likePattern(q.NamePrefix, false))
+	}
+	if q.NameContains != "" {
+		s.add(`lower(name) LIKE %s ESCAPE '\'`, likePattern(q.NameContains, true))
+	}
+	if q.Type != "" {
+		s.add("type = %s", q.Type.String())
+	}
+	if q.Owner != 0 {
+		s.add("owner = %s", q.Owner)
+	}
+	if q.Creator != 0 {
+		s.add("creator = %s", q.Creator)
+	}
+	if q.Parent != 0 {
+		s.add("parent = %s", q.Parent)
+	}
+}
+
+// query is the SQL to find the ids of the matching things.
+func (s *sqlSearch) query(limit int) string {
+	where := ""
+	if len(s.conditions) > 0 {
+		where = " WHERE " + strings.Join(s.conditions, " AND ")
+	}
+	return fmt.Sprintf("SELECT id FROM thing%s ORDER BY id LIMIT %d", where, limit)
+}
+
+// ParseTableValue reads a table value typed by a player to search for: "#12" for a thing, a JSON number, true or false, or a string (with or without JSON quotes).
+func ParseTableValue(text string) interface{} {
+	if strings.HasPrefix(text, "#") {
+		if id, err := strconv.ParseInt(text[1:], 10, 64); err == nil {
+			return ThingId(id)
+		}
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err == nil {
+		switch value.(type) {
+		case string, float64, bool:
+			return value
+		}
+	}
+	return text
+}
+
+// FindThingsFor runs the query against the World, returning the things it finds.
+func FindThingsFor(q *ThingQuery) ([]*Thing, error) {
+	err := q.Check()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := World.FindThings(q)
+	if err != nil {
+		return nil, err
+	}
+
+	things := make([]*Thing, 0, len(ids))
+	for _, id := range ids {
+		if thing := GetThing(id); thing != nil {
+			things = append(things, thing)
+		}
+	}
+	return things, nil
+}
+
+// FindThingsBy runs the query for a player (or program) searching, leaving out the things found by their table data that mayRead says the searcher can't read, as table data is only shown to a thing's editors. Searches by table data keep looking past the things left out until they've found as many as they can. It also reports whether the search found as many things as it could, so there may be more to find.
+func FindThingsBy(q *ThingQuery, mayRead func(*Thing) bool) ([]*Thing, bool, error) {
+	limit := q.ResultLimit()
+	if q.TableKey == "" {
+		things, err := FindThingsFor(q)
+		if err != nil {
+			return nil, false, err
+		}
+		return things, len(things) == limit, nil
+	}
+
+	err := q.Check()
+	if err != nil {
+		return nil, false, err
+	}
+	page := *q
+	readable := make([]*Thing, 0, limit)
+	for {
+		ids, err := World.FindThings(&page)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, id := range ids {
+			if thing := GetThing(id); thing != nil && mayRead(thing) {
+				readable = append(readable, thing)
+				if len(readable) == limit {
+					return readable, true, nil
+				}
+			}
+		}
+		if len(ids) < limit {
+			return readable, false, nil
+		}
+		page.After = ids[len(ids)-1]
+	}
+}
@@ -0,0 +1,110 @@
+package mess
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindThingsByTableData(t *testing.T) {
+	_, restore := useMemoryWorld()
+	defer restore()
+
+	origin := mustLoad(t, 1)
+	alice := mustCreate(t, "alice", PlayerThing, nil, origin)
+	bob := mustCreate(t, "bob", PlayerThing, nil, origin)
+	for _, owner := range []*Thing{alice, bob} {
+		thing := mustCreate(t, owner.Name+"'s safe", RegularThing, owner, origin)
+		thing.Table["combination"] = "1234"
+		if err := World.SaveThing(thing); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mayRead := func(thing *Thing) bool {
+		return thing.EditableById(alice.Id)
+	}
+	things, more, err := FindThingsBy(&ThingQuery{TableKey: "combination", TableValue: "1234"}, mayRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(things) != 1 || things[0].Name != "alice's safe" || more {
+		t.Errorf("searching by table data found %d things (more: %v), not only alice's safe", len(things), more)
+	}
+
+	things, _, err = FindThingsBy(&ThingQuery{NameContains: "safe"}, mayRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(things) != 2 {
+		t.Errorf("searching by name found %d things, not both safes", len(things))
+	}
+
+	// Things alice can't read that are found first don't use up her search.
+	for i := 0; i < 3; i++ {
+		thing := mustCreate(t, "bob's vault", RegularThing, bob, origin)
+		thing.Table["combination"] = "1234"
+		if err := World.SaveThing(thing); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := mustCreate(t, "alice's vault", RegularThing, alice, origin)
+	last.Table["combination"] = "1234"
+	if err := World.SaveThing(last); err != nil {
+		t.Fatal(err)
+	}
+	things, more, err = FindThingsBy(&ThingQuery{TableKey: "combination", TableValue: "1234", Limit: 2}, mayRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(things) != 2 || things[1].Id != last.Id || !more {
+		t.Errorf("searching two at a time by table data found %d things (more: %v), not alice's safe & vault", len(things), more)
+	}
+}
+
+func TestActiveWorldFindsUnsavedChanges(t *testing.T) {
+	active, _, restore := useActiveWorld(time.Hour)
+	defer restore()
+
+	origin := mustLoad(t, 1)
+	lamp := mustCreate(t, "lamp", RegularThing, nil, origin)
+	mustCreate(t, "lantern", RegularThing, nil, origin)
+	lamp.Name = "torch"
+	if err := World.SaveThing(lamp); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := World.FindThings(&ThingQuery{NamePrefix: "la"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] == lamp.Id {
+		t.Errorf("searching for the old name found %v, not only the lantern", ids)
+	}
+	ids, err = World.FindThings(&ThingQuery{NamePrefix: "torch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != lamp.Id {
+		t.Errorf("searching for the new name found %v, not only the lamp", ids)
+	}
+	if unsaved := active.CacheStats().Unsaved; unsaved != 1 {
+		t.Errorf("searching left %d unsaved things, not the changed lamp", unsaved)
+	}
+}
+
+func TestFindThingsByWholeName(t *testing.T) {
+	_, restore := useMemoryWorld()
+	defer restore()
+
+	origin := mustLoad(t, 1)
+	mustCreate(t, "Lamp post", RegularThing, nil, origin)
+	lamp := mustCreate(t, "lamp", RegularThing, nil, origin)
+
+	ids, err := World.FindThings(&ThingQuery{Name: "LAMP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != lamp.Id {
+		t.Errorf("searching for the name lamp found %v, not only the lamp", ids)
+	}
+}
@@ -2,19 +2,43 @@ package mess
 
 import (
 	"database/sql"
+	"fmt"
 	_ "github.com/bmizerany/pq"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 var Config struct {
 	Debug        bool
 	ServiceName  string
 	HostName     string
+	Driver       string
 	Dsn          string
 	GameAddress  string
 	WebAddress   string
 	CookieSecret string
+
+	// CacheSize is how many things to keep in memory (default 10000).
+	CacheSize int
+	// CacheMissingSeconds is how long to remember that a thing doesn't exist (default 60).
+	CacheMissingSeconds int
+	// SaveSeconds is how often to save changed things to the database (default 5). Negative means save every change right away.
+	SaveSeconds int
+
+	// LuaInstructionLimit is how many Lua instructions a program can run each time it's called (default 1000000).
+	LuaInstructionLimit int
+	// LuaMemoryKB is how much memory each program can use, in kilobytes (default 4096).
+	LuaMemoryKB int
+	// LuaTimeoutMillis is how long a program can run each time it's called, in milliseconds (default 1000).
+	LuaTimeoutMillis int
+
+	// TimerQuota is how many timers each player's programs can have scheduled at once (default 20).
+	TimerQuota int
+	// CreateQuota is how many things each call to a player's programs can make (default 10).
+	CreateQuota int
 }
 
 func OpenDatabase() (*DatabaseWorld, error) {
@@ -25,10 +49,65 @@ func OpenDatabase() (*DatabaseWorld, error) {
 	return &DatabaseWorld{db}, nil
 }
 
+// OpenStores opens the world & account storage selected by Config.Driver.
+func OpenStores() (WorldStore, AccountStore, error) {
+	switch Config.Driver {
+	case "", "postgres":
+		db, err := OpenDatabase()
+		if err != nil {
+			return nil, nil, err
+		}
+		err = CheckSchema(db.db, Migrations)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, db, nil
+	case "sqlite3":
+		db, err := OpenSqliteDatabase()
+		if err != nil {
+			return nil, nil, err
+		}
+		err = CheckSchema(db.db, Migrations)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, db, nil
+	case "memory":
+		mem := NewMemoryWorld()
+		return mem, mem, nil
+	}
+	return nil, nil, fmt.Errorf("unknown database driver %q", Config.Driver)
+}
+
+// SaveOnShutdown waits for the mess to be told to stop, then saves all the changed things before exiting.
+func SaveOnShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	log.Println("Received", sig, "signal, so saving changes and shutting down")
+
+	// Hold the world lock until we exit, so no more changes are made that won't be saved.
+	worldLock.Lock()
+	if active, ok := World.(*ActiveWorld); ok {
+		err := active.SaveDirty()
+		if err != nil {
+			log.Println("Error saving changed things at shutdown:", err.Error())
+			os.Exit(1)
+		}
+	}
+	os.Exit(0)
+}
+
 func Server() {
-	GameInit()
+	err := GameInit()
+	if err != nil {
+		log.Println("Error connecting to database:", err)
+		return
+	}
 
 	go StartWeb()
+	go SaveOnShutdown()
+	go Timers.KeepRunning()
 
 	// TODO: listen on an SSL port too
 	log.Println("Listening at address", Config.GameAddress)
@@ -1,29 +1,159 @@
 package mess
 
 import (
+	"errors"
 	"fmt"
 	"github.com/aarzilli/golua/lua"
 	"log"
 	"strings"
+	"time"
 	"unsafe"
 )
 
 const ThingMetaTableName = "Mess.Thing"
 
+// ThingTypesRegistryKey is the registry table of the thing type sentinels, by the names they have in `world`. They're read from here rather than `world`, which programs can change.
+const ThingTypesRegistryKey = "Mess.ThingTypes"
+
+// ThingProgram is a thing's Lua program, compiled into its own Lua state. Like everything else that touches things, it must only be called with the world locked (see WithWorld), so only one goroutine at a time is ever in its state.
 type ThingProgram struct {
-	Text  string
-	Error error
-	state *lua.State
+	Text   string
+	Error  error
+	Limits ProgramLimits
+	// Thing is the id of the thing the program belongs to, as of its latest call.
+	Thing ThingId
+	// Identity is who the program's next call acts as.
+	Identity ProgramIdentity
+	state    *lua.State
+	memory   luaMemory
+	// frames are the calls in progress, innermost last.
+	frames []programFrame
+	// libraries are registry references to the values of the libraries the program has required, by their things' ids, and loading is the libraries being loaded, innermost last.
+	libraries map[ThingId]int
+	loading   []ThingId
+	// errorReported is whether Error has been returned from a call, so it isn't reported for every call.
+	errorReported bool
+	// created is how many things the call in progress has made with world.create, counting calls from inside it.
+	created int
+	// timers is how many anonymous timers the program has scheduled, which live only in its state.
+	timers int
+	// closed is whether the program has been replaced, so its state is closed (or will be, once its calls in progress are done).
+	closed bool
 }
 
-func NewProgram(text string) (p *ThingProgram) {
-	p = &ThingProgram{
-		Text: text,
+// programFrame is a call in progress: a registry reference to its environment table, and who it acts as.
+type programFrame struct {
+	env      int
+	identity ProgramIdentity
+}
+
+// MaxCallDepth is how many calls into one program can be in progress at once, as when a program's actions cause it to be called again.
+const MaxCallDepth = 10
+
+// ProgramLimits are how much a program can do, so a broken or hostile one can't hang or exhaust the mess. A zero limit means no limit.
+type ProgramLimits struct {
+	// Instructions is how many Lua instructions the program can run in one call.
+	Instructions int
+	// MemoryKB is how much memory the program's Lua state can use in all, in kilobytes.
+	MemoryKB int
+	// Timeout is how long the program can run in one call.
+	Timeout time.Duration
+}
+
+// DefaultProgramLimits are the limits for programs from the Config.
+func DefaultProgramLimits() ProgramLimits {
+	limits := ProgramLimits{
+		Instructions: Config.LuaInstructionLimit,
+		MemoryKB:     Config.LuaMemoryKB,
+		Timeout:      time.Duration(Config.LuaTimeoutMillis) * time.Millisecond,
+	}
+	if limits.Instructions == 0 {
+		limits.Instructions = 1000000
+	}
+	if limits.MemoryKB == 0 {
+		limits.MemoryKB = 4096
+	}
+	if limits.Timeout == 0 {
+		limits.Timeout = time.Second
 	}
+	return limits
+}
+
+func NewProgram(text string) (p *ThingProgram) {
+	p = LoadProgram(text)
 	p.compile()
 	return p
 }
 
+// LoadProgram makes a program that's compiled the first time it's called, rather than right away like NewProgram. Stores load programs this way, as compiling a program runs it, and it may look up other things (such as libraries it requires) that can't be loaded while the store is busy loading its thing.
+func LoadProgram(text string) *ThingProgram {
+	return &ThingProgram{
+		Text:   text,
+		Limits: DefaultProgramLimits(),
+	}
+}
+
+// compiled reports whether the program is ready to call, compiling it first if it was loaded with LoadProgram. The first time it's asked of a program that failed to compile, the program's Error is returned too, so it's reported once rather than skipping calls silently.
+func (p *ThingProgram) compiled() (bool, error) {
+	if p.closed {
+		return false, nil
+	}
+	if p.state == nil && p.Error == nil {
+		p.compile()
+	}
+	if p.Error != nil && !p.errorReported {
+		p.errorReported = true
+		return false, p.Error
+	}
+	return p.Error == nil, nil
+}
+
+// Close frees the program's Lua state when the program is replaced, such as by ChangeProgramBy. If calls are in progress in the state, it's freed when the last one finishes instead. A closed program can't be called again.
+func (p *ThingProgram) Close() {
+	p.closed = true
+	if len(p.frames) == 0 {
+		p.closeState()
+	}
+}
+
+func (p *ThingProgram) closeState() {
+	if p.state != nil {
+		p.state.Close()
+		p.state = nil
+	}
+	p.libraries = nil
+}
+
+// limitHookInterval is how many instructions a program runs between checks of its limits.
+const limitHookInterval = 1000
+
+// startLimits sets up the program's limits for running it once, raising an error in the program when it hits one.
+func (p *ThingProgram) startLimits(state *lua.State) {
+	limits := p.Limits
+	p.memory.limit = limits.MemoryKB * 1024
+	p.memory.refused = false
+
+	deadline := time.Now().Add(limits.Timeout)
+	instructions := 0
+	state.SetHook(func(state *lua.State) {
+		instructions += limitHookInterval
+		if limits.Instructions > 0 && instructions > limits.Instructions {
+			state.RaiseError(fmt.Sprintf("program ran more than its limit of %d instructions", limits.Instructions))
+		}
+		if limits.Timeout > 0 && time.Now().After(deadline) {
+			state.RaiseError(fmt.Sprintf("program ran longer than its limit of %s", limits.Timeout))
+		}
+	}, limitHookInterval)
+}
+
+// limitError explains err if it was because the program ran out of memory.
+func (p *ThingProgram) limitError(err error) error {
+	if err != nil && p.memory.refused {
+		return fmt.Errorf("program used more than its limit of %d KB of memory", p.Limits.MemoryKB)
+	}
+	return err
+}
+
 func pushValue(state *lua.State, value interface{}) error {
 	switch v := value.(type) {
 	default:
@@ -48,6 +178,25 @@ func pushValue(state *lua.State, value interface{}) error {
 		log.Println("Pushing bool onto lua stack")
 		state.PushBoolean(v)
 
+	case []interface{}:
+		log.Println("Pushing []interface{} onto lua stack")
+		state.CreateTable(len(v), 0)
+		for i, value := range v {
+			err := pushValue(state, value)
+			if err != nil {
+				state.Pop(1)
+				return err
+			}
+			state.RawSeti(-2, i+1)
+		}
+	case ThingIdList:
+		log.Println("Pushing ThingIdList onto lua stack")
+		state.CreateTable(len(v), 0)
+		for i, id := range v {
+			pushValue(state, id)
+			state.RawSeti(-2, i+1)
+		}
+
 	case map[string]interface{}:
 		log.Println("Pushing map[string]interface{} onto lua stack")
 		state.CreateTable(0, len(v))
@@ -64,9 +213,9 @@ func pushValue(state *lua.State, value interface{}) error {
 
 	case ThingType:
 		// These are singleton sentinel values, so load them from Lua-land.
-		state.GetGlobal("world")
-		state.GetField(-1, strings.Title(v.String()))
-		state.Remove(-2)
+		state.GetField(lua.LUA_REGISTRYINDEX, ThingTypesRegistryKey) // ( -- tblTypes )
+		state.GetField(-1, strings.Title(v.String()))                // ( tblTypes -- tblTypes sentinel )
+		state.Remove(-2)                                             // ( tblTypes sentinel -- sentinel )
 
 	case *Thing:
 		log.Println("Pushing *Thing onto lua stack")
@@ -94,6 +243,105 @@ func pushValue(state *lua.State, value interface{}) error {
 	return nil
 }
 
+// maxValueDepth is how deeply tables can be nested in a value read from Lua, so a table that contains itself isn't read forever.
+const maxValueDepth = 32
+
+// toValue reads the Lua value at index as a Go value, as pushValue would push it: nil, a string, float64, bool, ThingId (for a Thing), ThingType (for one of world's type sentinels), []interface{} (for a table with keys 1 to n) or map[string]interface{} (for a table with string keys). Other values, like functions, can't be read.
+func toValue(state *lua.State, index int) (interface{}, error) {
+	if index < 0 {
+		// Reading tables pushes their keys & values, which would move a relative index.
+		index = state.GetTop() + index + 1
+	}
+	return toValueAt(state, index, 0)
+}
+
+func toValueAt(state *lua.State, index int, depth int) (interface{}, error) {
+	switch state.Type(index) {
+	case lua.LUA_TNIL, lua.LUA_TNONE:
+		return nil, nil
+	case lua.LUA_TSTRING:
+		return state.ToString(index), nil
+	case lua.LUA_TNUMBER:
+		return state.ToNumber(index), nil
+	case lua.LUA_TBOOLEAN:
+		return state.ToBoolean(index), nil
+	case lua.LUA_TUSERDATA:
+		if id, ok := toThingId(state, index); ok {
+			return id, nil
+		}
+		if tt := toThingType(state, index); tt != "" {
+			return tt, nil
+		}
+	case lua.LUA_TTABLE:
+		if depth >= maxValueDepth {
+			return nil, fmt.Errorf("tables can only be nested %d deep", maxValueDepth)
+		}
+		return toTableAt(state, index, depth+1)
+	}
+	return nil, fmt.Errorf("can't use a %s value", state.LTypename(index))
+}
+
+// toTableAt reads the Lua table at the (positive) index as a list, if its keys are the numbers 1 to n, or else a map of its string keys.
+func toTableAt(state *lua.State, index int, depth int) (interface{}, error) {
+	fields := make(map[string]interface{})
+	items := make(map[int]interface{})
+
+	state.PushNil()              // ( -- nil )
+	for state.Next(index) != 0 { // ( key -- key val )
+		value, err := toValueAt(state, state.GetTop(), depth)
+		if err != nil {
+			state.Pop(2) // ( key val -- )
+			return nil, err
+		}
+
+		switch state.Type(-2) {
+		case lua.LUA_TSTRING:
+			fields[state.ToString(-2)] = value
+		case lua.LUA_TNUMBER:
+			n := state.ToNumber(-2)
+			if n < 1 || n != float64(int(n)) {
+				state.Pop(2) // ( key val -- )
+				return nil, fmt.Errorf("list tables can only have keys 1, 2, 3 & so on, not %v", n)
+			}
+			items[int(n)] = value
+		default:
+			state.Pop(2) // ( key val -- )
+			return nil, fmt.Errorf("tables can only have string or number keys, not %s", state.LTypename(-2))
+		}
+		state.Pop(1) // ( key val -- key )
+	}
+
+	if len(items) == 0 {
+		return fields, nil
+	}
+	if len(fields) > 0 {
+		return nil, errors.New("tables can't have both string keys & list items")
+	}
+	list := make([]interface{}, len(items))
+	for i := range list {
+		value, ok := items[i+1]
+		if !ok {
+			return nil, fmt.Errorf("list tables can't have gaps, but item %d is missing", i+1)
+		}
+		list[i] = value
+	}
+	return list, nil
+}
+
+// toThingId reads the Thing at index as its ThingId, returning false if it isn't a Thing. Unlike checkThing, it doesn't raise a Lua error, so it can read values outside of a call from Lua.
+func toThingId(state *lua.State, index int) (ThingId, bool) {
+	if !state.GetMetaTable(index) { // ( -- mtbl? )
+		return 0, false
+	}
+	state.LGetMetaTable(ThingMetaTableName) // ( mtbl -- mtbl mtblThing )
+	isThing := state.RawEqual(-1, -2)
+	state.Pop(2) // ( mtbl mtblThing -- )
+	if !isThing {
+		return 0, false
+	}
+	return ThingId(*(*int64)(state.ToUserdata(index))), true
+}
+
 func checkThing(state *lua.State, argNum int) *Thing {
 	userdata := state.CheckUdata(argNum, ThingMetaTableName)
 	if userdata == nil {
@@ -104,7 +352,7 @@ func checkThing(state *lua.State, argNum int) *Thing {
 	thingPtr = (*int64)(userdata)
 	thingId := ThingId(*thingPtr)
 
-	thing := World.ThingForId(thingId)
+	thing := GetThing(thingId)
 	if thing == nil {
 		state.ArgError(argNum, "`Thing` argument is no longer valid")
 	}
@@ -112,21 +360,26 @@ func checkThing(state *lua.State, argNum int) *Thing {
 	return thing
 }
 
-type MessThingMember func(state *lua.State, thing *Thing) int
+type MessThingMember func(p *ThingProgram, state *lua.State, thing *Thing) int
 
-func MessThingContents(state *lua.State, thing *Thing) int {
-	// make a new table
-	state.CreateTable(len(thing.Contents), 0) // ( -- tbl )
-	// for each content add a new lua-space Thing
-	for i, contentId := range thing.Contents {
-		state.PushInteger(int64(i))
-		pushValue(state, contentId)
-		state.SetTable(-3) // ( tbl key val -- tbl )
-	} // ( tbl -- tbl )
+func MessThingAllowsMethod(p *ThingProgram, state *lua.State, thing *Thing) int {
+	state.PushGoFunction(func(state *lua.State) int {
+		thing := checkThing(state, 1)
+		player := checkThing(state, 2)
+
+		state.PushBoolean(thing.AllowedById(player.Id))
+		return 1
+	})
 	return 1
 }
 
-func MessThingFindnearMethod(state *lua.State, thing *Thing) int {
+func MessThingContents(p *ThingProgram, state *lua.State, thing *Thing) int {
+	// A list of lua-space Things, from 1 like other Lua lists.
+	pushValue(state, thing.Contents) // ( -- tbl )
+	return 1
+}
+
+func MessThingFindnearMethod(p *ThingProgram, state *lua.State, thing *Thing) int {
 	state.PushGoFunction(func(state *lua.State) int {
 		thing := checkThing(state, 1)
 		text := state.CheckString(2)
@@ -144,7 +397,7 @@ func MessThingFindnearMethod(state *lua.State, thing *Thing) int {
 	return 1
 }
 
-func MessThingFindinsideMethod(state *lua.State, thing *Thing) int {
+func MessThingFindinsideMethod(p *ThingProgram, state *lua.State, thing *Thing) int {
 	state.PushGoFunction(func(state *lua.State) int {
 		thing := checkThing(state, 1)
 		text := state.CheckString(2)
@@ -162,25 +415,38 @@ func MessThingFindinsideMethod(state *lua.State, thing *Thing) int {
 	return 1
 }
 
-func MessThingMovetoMethod(state *lua.State, thing *Thing) int {
+func MessThingMovetoMethod(p *ThingProgram, state *lua.State, thing *Thing) int {
 	state.PushGoFunction(func(state *lua.State) int {
 		source := checkThing(state, 1)
 		target := checkThing(state, 2)
 
-		ok := source.MoveTo(target)
+		// Programs can move what they can edit, into what they can edit or places that let their player in.
+		if !p.mayEdit(source) || !(p.mayEdit(target) || target.Type == PlaceThing && p.identity().Can(target.AllowedById)) {
+			state.PushBoolean(false)
+			state.PushString(fmt.Sprintf("the program isn't allowed to move %s to %s", source.Name, target.Name))
+			return 2
+		}
 
-		state.PushBoolean(ok)
+		err := source.MoveTo(target)
+		if err != nil {
+			// Like Lua's own functions, return false & the reason.
+			state.PushBoolean(false)
+			state.PushString(err.Error())
+			return 2
+		}
+
+		state.PushBoolean(true)
 		return 1
 	})
 	return 1
 }
 
-func MessThingName(state *lua.State, thing *Thing) int {
+func MessThingName(p *ThingProgram, state *lua.State, thing *Thing) int {
 	state.PushString(thing.Name)
 	return 1
 }
 
-func MessThingPronounsubMethod(state *lua.State, thing *Thing) int {
+func MessThingPronounsubMethod(p *ThingProgram, state *lua.State, thing *Thing) int {
 	state.PushGoFunction(func(state *lua.State) int {
 		thing := checkThing(state, 1)
 		text := state.CheckString(2)
@@ -202,21 +468,43 @@ func MessThingPronounsubMethod(state *lua.State, thing *Thing) int {
 	return 1
 }
 
-func MessThingTellMethod(state *lua.State, thing *Thing) int {
+func MessThingRecycleMethod(p *ThingProgram, state *lua.State, thing *Thing) int {
+	state.PushGoFunction(func(state *lua.State) int {
+		thing := checkThing(state, 1)
+
+		// Programs can only recycle what their player could, and not their own thing.
+		ok := thing.Id != p.Thing && p.identity().Can(thing.DestroyableById)
+		if ok {
+			ok = RecycleThing(thing) == nil
+		}
+
+		state.PushBoolean(ok)
+		return 1
+	})
+	return 1
+}
+
+func MessThingTellMethod(p *ThingProgram, state *lua.State, thing *Thing) int {
 	state.PushGoFunction(func(state *lua.State) int {
 		thing := checkThing(state, 1)
 		text := state.CheckString(2)
+		state.Pop(2) // ( udataThing strText -- )
 
+		if !p.mayReach(thing) {
+			state.PushBoolean(false)
+			state.PushString(fmt.Sprintf("the program isn't allowed to tell %s anything", thing.Name))
+			return 2
+		}
 		if thing.Client != nil {
 			thing.Client.Send(text)
 		}
-		state.Pop(2) // ( udataThing strText -- )
-		return 0
+		state.PushBoolean(true)
+		return 1
 	})
 	return 1
 }
 
-func MessThingTellallMethod(state *lua.State, thing *Thing) int {
+func MessThingTellallMethod(p *ThingProgram, state *lua.State, thing *Thing) int {
 	state.PushGoFunction(func(state *lua.State) int {
 		place := checkThing(state, 1)
 		text := state.CheckString(2)
@@ -235,8 +523,14 @@ func MessThingTellallMethod(state *lua.State, thing *Thing) int {
 			}
 		}
 
+		if !p.mayReach(place) {
+			state.PushBoolean(false)
+			state.PushString(fmt.Sprintf("the program isn't allowed to tell %s anything", place.Name))
+			return 2
+		}
+		identity := p.identity()
 		for _, content := range place.GetContents() {
-			if excludes[content.Id] {
+			if excludes[content.Id] || !identity.Can(func(playerId ThingId) bool { return !content.DeniedById(playerId) }) {
 				continue
 			}
 			if content.Client != nil {
@@ -244,29 +538,44 @@ func MessThingTellallMethod(state *lua.State, thing *Thing) int {
 			}
 		}
 
-		return 0
+		state.PushBoolean(true)
+		return 1
 	})
 	return 1
 }
 
-func MessThingType(state *lua.State, thing *Thing) int {
+func MessThingType(p *ThingProgram, state *lua.State, thing *Thing) int {
 	pushValue(state, thing.Type)
 	return 1
 }
 
-var MessThingMembers map[string]MessThingMember = map[string]MessThingMember{
-	"contents":   MessThingContents,
-	"findinside": MessThingFindinsideMethod,
-	"findnear":   MessThingFindnearMethod,
-	"moveto":     MessThingMovetoMethod,
-	"name":       MessThingName,
-	"pronounsub": MessThingPronounsubMethod,
-	"tell":       MessThingTellMethod,
-	"tellall":    MessThingTellallMethod,
-	"type":       MessThingType,
+var MessThingMembers map[string]MessThingMember
+
+// The members refer back to MessThingMembers through calls into programs, so they can't be set in its declaration.
+func init() {
+	MessThingMembers = map[string]MessThingMember{
+		"allows":     MessThingAllowsMethod,
+		"contents":   MessThingContents,
+		"findinside": MessThingFindinsideMethod,
+		"findnear":   MessThingFindnearMethod,
+		"moveto":     MessThingMovetoMethod,
+		"name":       MessThingName,
+		"pronounsub": MessThingPronounsubMethod,
+		"recycle":    MessThingRecycleMethod,
+		"tell":       MessThingTellMethod,
+		"tellall":    MessThingTellallMethod,
+		"type":       MessThingType,
+	}
+}
+
+// MessThingIndex makes the __index metamethod for the program's Things, which finds their members & table data.
+func MessThingIndex(p *ThingProgram) lua.LuaGoFunction {
+	return func(state *lua.State) int {
+		return p.thingIndex(state)
+	}
 }
 
-func MessThingIndex(state *lua.State) int {
+func (p *ThingProgram) thingIndex(state *lua.State) int {
 	log.Println("HEY WE MADE IT")
 	printStackTypes(state)
 
@@ -284,11 +593,11 @@ func MessThingIndex(state *lua.State) int {
 	log.Println("So we're tryin'a look up", fieldName, "on thing", thing.Id)
 
 	if member, ok := MessThingMembers[fieldName]; ok {
-		return member(state, thing)
+		return member(p, state, thing)
 	}
 
-	// That wasn't one of our members, so look it up in our Table.
-	if data, ok := thing.Table[fieldName]; ok {
+	// That wasn't one of our members, so look it up in our Table (or our prototypes').
+	if data, from := thing.Lookup(fieldName); from != nil {
 		// TODO: instead of pushing a whole map if the script asks for one, maybe we should use another kind of userdata that tracks the name & can access its submembers until the script asks for the leaf (or a non-existent branch)?
 		pushValue(state, data)
 		return 1
@@ -372,33 +681,148 @@ func TableFormat(state *lua.State) int {
 	return 1
 }
 
+// toThingType reads the thing type sentinel (such as world.Place) at index, or returns "" if it isn't one.
+func toThingType(state *lua.State, index int) ThingType {
+	if index < 0 {
+		// Pushing the sentinels would move a relative index.
+		index = state.GetTop() + index + 1
+	}
+	for _, tt := range []ThingType{RegularThing, PlaceThing, PlayerThing, ActionThing, ProgramThing} {
+		pushValue(state, tt) // ( -- sentinel )
+		isType := state.RawEqual(index, -1)
+		state.Pop(1) // ( sentinel -- )
+		if isType {
+			return tt
+		}
+	}
+	return ""
+}
+
+// checkTableData returns an error if the value read by toValue can't be kept in a thing's table data, which is saved as JSON.
+func checkTableData(value interface{}) error {
+	switch v := value.(type) {
+	case ThingType:
+		return errors.New("things can't hold thing types")
+	case []interface{}:
+		for _, item := range v {
+			if err := checkTableData(item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if err := checkTableData(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findThingField reads the thing in field of the query table at index 1, for world.find().
+func findThingField(state *lua.State, field string) ThingId {
+	state.GetField(1, field)
+	defer state.Pop(1)
+	if state.IsNil(-1) {
+		return 0
+	}
+	return checkThing(state, state.GetTop()).Id
+}
+
+// MessWorldFind makes world.find for the program, which searches the world for things, as `world.find{name="lamp", type=world.Thing, owner=me, key="lit", value=true, limit=5}`. Searches by table key only find things the program can edit. It returns a list of the things found, or nil & the reason it couldn't search.
+func MessWorldFind(p *ThingProgram) lua.LuaGoFunction {
+	return func(state *lua.State) int {
+		return p.worldFind(state)
+	}
+}
+
+func (p *ThingProgram) worldFind(state *lua.State) int {
+	state.CheckType(1, lua.LUA_TTABLE)
+	query := &ThingQuery{
+		Owner:   findThingField(state, "owner"),
+		Creator: findThingField(state, "creator"),
+		Parent:  findThingField(state, "parent"),
+	}
+
+	stringFields := map[string]*string{
+		"name":   &query.NameContains,
+		"prefix": &query.NamePrefix,
+		"key":    &query.TableKey,
+	}
+	for field, value := range stringFields {
+		state.GetField(1, field)
+		if state.IsString(-1) {
+			*value = state.ToString(-1)
+		}
+		state.Pop(1)
+	}
+
+	state.GetField(1, "limit")
+	if state.IsNumber(-1) {
+		query.Limit = state.ToInteger(-1)
+	}
+	state.Pop(1)
+
+	state.GetField(1, "type") // ( -- type? )
+	if !state.IsNil(-1) {
+		query.Type = toThingType(state, -1)
+		if query.Type == "" {
+			state.ArgError(1, "`type` should be one of world's thing types, like world.Place")
+		}
+	}
+	state.Pop(1) // ( type? -- )
+
+	state.GetField(1, "value") // ( -- value? )
+	value, err := toValue(state, -1)
+	if err != nil {
+		state.ArgError(1, fmt.Sprintf("`value` %s", err.Error()))
+	}
+	query.TableValue = value
+	state.Pop(1) // ( value? -- )
+
+	things, _, err := FindThingsBy(query, p.mayEdit)
+	if err != nil {
+		// Like Lua's own functions, return nil & the reason.
+		state.PushNil()
+		state.PushString(StoreErrorMessage(err))
+		return 2
+	}
+
+	state.CreateTable(len(things), 0) // ( -- tbl )
+	for i, thing := range things {
+		state.PushInteger(int64(i + 1))
+		pushValue(state, thing.Id)
+		state.SetTable(-3) // ( tbl key val -- tbl )
+	}
+	return 1
+}
+
 func installWorld(state *lua.State) {
 	log.Println("Installing world")
 	printStackTypes(state)
 
-	state.NewMetaTable(ThingMetaTableName)         // ( -- mtbl )
-	state.SetMetaMethod("__index", MessThingIndex) // ( mtbl -- mtbl )
-	state.Pop(1)                                   // ( mtbl -- )
+	// The metamethods are per program, so they're added by installThingMethods.
+	state.NewMetaTable(ThingMetaTableName) // ( -- mtbl )
+	state.Pop(1)                           // ( mtbl -- )
 
 	worldTable := map[string]interface{}{
 	/*
 		"Root":
-		"Create": func...
 	*/
 	}
 	pushValue(state, worldTable)
 
-	// Install Thing types as singleton sentinel values. As userdata, these will only compare if the values are exactly equal.
-	state.NewUserdata(uintptr(0))
-	state.SetField(-2, "Player")
-	state.NewUserdata(uintptr(0))
-	state.SetField(-2, "Place")
-	state.NewUserdata(uintptr(0))
-	state.SetField(-2, "Program")
-	state.NewUserdata(uintptr(0))
-	state.SetField(-2, "Action")
-	state.NewUserdata(uintptr(0))
-	state.SetField(-2, "Thing")
+	// Install Thing types as singleton sentinel values. As userdata, these will only compare if the values are exactly equal. They're kept in the registry too, where programs can't replace them.
+	state.NewTable() // ( tblWorld -- tblWorld tblTypes )
+	for _, name := range []string{"Player", "Place", "Program", "Action", "Thing"} {
+		state.NewUserdata(uintptr(0)) // ( tblWorld tblTypes -- tblWorld tblTypes sentinel )
+		state.PushValue(-1)           // ( sentinel -- sentinel sentinel )
+		state.SetField(-3, name)      // ( tblTypes sentinel sentinel -- tblTypes sentinel )
+		state.SetField(-3, name)      // ( tblWorld tblTypes sentinel -- tblWorld tblTypes )
+	}
+	state.SetField(lua.LUA_REGISTRYINDEX, ThingTypesRegistryKey) // ( tblWorld tblTypes -- tblWorld )
+
+	// world.find is per program, so it's added by compile.
 
 	state.SetGlobal("world")
 
@@ -411,25 +835,215 @@ func installWorld(state *lua.State) {
 	printStackTypes(state)
 }
 
+// identity is who the call in progress acts as. Outside a call, such as while the program is first run, it acts as no one.
+func (p *ThingProgram) identity() ProgramIdentity {
+	if len(p.frames) == 0 {
+		return ProgramIdentity{}
+	}
+	return p.frames[len(p.frames)-1].identity
+}
+
+// mayEdit reports whether the call in progress can change the thing.
+func (p *ThingProgram) mayEdit(thing *Thing) bool {
+	return p.identity().Can(thing.EditableById)
+}
+
+// mayReach reports whether the call in progress can send text to the thing: the thing mustn't deny the program's player (or caller), and must be editable by them or near the program's thing (where it is, next to it, or inside it).
+func (p *ThingProgram) mayReach(thing *Thing) bool {
+	identity := p.identity()
+	if !identity.Can(func(playerId ThingId) bool { return !thing.DeniedById(playerId) }) {
+		return false
+	}
+	if identity.Can(thing.EditableById) {
+		return true
+	}
+	self := GetThing(p.Thing)
+	return self != nil && (thing.Id == self.Parent || thing.Parent == self.Parent || thing.Parent == self.Id)
+}
+
+// installThingMethods sets the metamethods for the program's Things: reading their members & table data, and setting their table data, as `thing.description = "A lamp."`, if the program can edit the thing. Setting a key to nil removes it.
+func (p *ThingProgram) installThingMethods(state *lua.State) {
+	state.LGetMetaTable(ThingMetaTableName) // ( -- mtbl )
+	state.PushGoClosure(MessThingIndex(p))
+	state.SetField(-2, "__index")
+	state.PushGoClosure(func(state *lua.State) int {
+		// ( udataThing key val -- udataThing key val )
+		thing := checkThing(state, 1)
+		key := state.CheckString(2)
+		if _, ok := MessThingMembers[key]; ok {
+			state.ArgError(2, fmt.Sprintf("`%s` is part of every thing, so it can't be set", key))
+		}
+		value, err := toValue(state, 3)
+		if err == nil {
+			err = checkTableData(value)
+		}
+		if err != nil {
+			state.ArgError(3, err.Error())
+		}
+
+		if !p.mayEdit(thing) {
+			state.RaiseError(fmt.Sprintf("can't change %s, as the program isn't allowed to edit it", thing.Name))
+			return 0
+		}
+		if identity := p.identity(); !MayChangeTableKey(identity.Player, key) || identity.Caller != 0 && !MayChangeTableKey(identity.Caller, key) {
+			state.RaiseError(fmt.Sprintf("can't change %s's `%s`, as only superusers can", thing.Name, key))
+			return 0
+		}
+
+		if value == nil {
+			delete(thing.Table, key)
+		} else {
+			thing.Table[key] = value
+		}
+		err = SaveThingBy(p.identity().Player, thing)
+		if err != nil {
+			state.RaiseError(fmt.Sprintf("couldn't save %s: %s", thing.Name, StoreErrorMessage(err)))
+		}
+		return 0
+	})
+	state.SetField(-2, "__newindex")
+	state.Pop(1) // ( mtbl -- )
+}
+
+// CreateQuota is how many things each call to a program can make with world.create, from the Config. Superusers' programs have no quota.
+func CreateQuota() int {
+	if Config.CreateQuota == 0 {
+		return 10
+	}
+	return Config.CreateQuota
+}
+
+// installCreate adds world.create to the `world` table on top of the stack, for making new things owned by the program's player, as `world.create("Lamp", world.Thing, here)`. Without a parent, new places are made in the first place and other things are given to the player. The program must be allowed to edit the parent, even if it's the first place. Each call can make only CreateQuota things. It returns the new thing, or nil & the reason it couldn't be made.
+func (p *ThingProgram) installCreate(state *lua.State) {
+	state.PushGoClosure(func(state *lua.State) int {
+		// ( name type parent? -- )
+		name := state.CheckString(1)
+		tt := toThingType(state, 2)
+		if tt == "" {
+			state.ArgError(2, "expected one of world's thing types, like world.Place")
+		}
+		if tt == PlayerThing {
+			state.ArgError(2, "players can only be made by registering")
+		}
+		var parent *Thing
+		if !state.IsNoneOrNil(3) {
+			parent = checkThing(state, 3)
+		}
+
+		fail := func(reason string) int {
+			// Like Lua's own functions, return nil & the reason.
+			state.PushNil()
+			state.PushString(reason)
+			return 2
+		}
+
+		player := GetThing(p.identity().Player)
+		if player == nil {
+			return fail("the program isn't acting for anyone, so it can't make things")
+		}
+		if !player.Superuser && p.created >= CreateQuota() {
+			return fail(fmt.Sprintf("the program already made %d things this call, which is as many as it can", CreateQuota()))
+		}
+		if parent == nil && tt == PlaceThing {
+			parent = GetThing(1)
+			if parent == nil {
+				return fail("there's no first place to make the place in")
+			}
+		}
+		if parent == nil {
+			parent = player
+		} else if !p.mayEdit(parent) {
+			return fail(fmt.Sprintf("can't make things in %s, as the program isn't allowed to edit it", parent.Name))
+		}
+
+		thing, err := World.CreateThing(name, tt, player, parent)
+		if err != nil {
+			return fail(StoreErrorMessage(err))
+		}
+		p.created++
+		NotifyCreated(thing)
+		pushValue(state, thing.Id)
+		return 1
+	})
+	state.SetField(-2, "create")
+}
+
+// installEnvironment makes the call in progress's environment (such as `me` & `here`) readable as globals, without storing it in the program's global table. Assigning to one of those names changes it only for that call; other new globals are kept as usual.
+func (p *ThingProgram) installEnvironment(state *lua.State) {
+	state.PushValue(lua.LUA_GLOBALSINDEX) // ( -- G )
+	state.CreateTable(0, 2)               // ( G -- G mtbl )
+
+	state.PushGoClosure(func(state *lua.State) int {
+		// ( G key -- G key )
+		if len(p.frames) == 0 {
+			return 0
+		}
+		state.RawGeti(lua.LUA_REGISTRYINDEX, p.frames[len(p.frames)-1].env) // ( G key -- G key env )
+		state.PushValue(2)                                                  // ( G key env -- G key env key )
+		state.RawGet(-2)                                                    // ( G key env key -- G key env val )
+		return 1
+	})
+	state.SetField(-2, "__index")
+
+	state.PushGoClosure(func(state *lua.State) int {
+		// ( G key val -- G key val )
+		if len(p.frames) > 0 {
+			state.RawGeti(lua.LUA_REGISTRYINDEX, p.frames[len(p.frames)-1].env) // ( G key val -- G key val env )
+			state.PushValue(2)                                                  // ( G key val env -- G key val env key )
+			state.RawGet(-2)                                                    // ( G key val env key -- G key val env old )
+			if !state.IsNil(-1) {
+				state.Pop(1)       // ( G key val env old -- G key val env )
+				state.PushValue(2) // ( G key val env -- G key val env key )
+				state.PushValue(3) // ( G key val env key -- G key val env key val )
+				state.RawSet(-3)   // ( G key val env key val -- G key val env )
+				return 0
+			}
+			state.Pop(2) // ( G key val env old -- G key val )
+		}
+		state.RawSet(1) // ( G key val -- G )
+		return 0
+	})
+	state.SetField(-2, "__newindex")
+
+	state.SetMetaTable(-2) // ( G mtbl -- G )
+	state.Pop(1)           // ( G -- )
+}
+
 func (p *ThingProgram) compile() error {
-	state := lua.NewState()
+	p.libraries = make(map[ThingId]int)
+	p.loading = nil
+	state := lua.NewStateAlloc(p.memory.allocator())
 	state.OpenBase()
 	state.OpenMath()
 	state.OpenString()
 	state.OpenTable()
 	// Not IO & not OS.
-	// Not package: all our packages are preloaded.
+	// Not package: our packages are preloaded, and libraries are loaded from things by installRequire's require().
 
 	// Install the `world` package.
 	installWorld(state)
-
-	err := state.DoString(p.Text)
+	state.GetGlobal("world") // ( -- tblWorld )
+	state.PushGoClosure(MessWorldFind(p))
+	state.SetField(-2, "find")
+	p.installCreate(state)
+	p.installTimers(state)
+	state.Pop(1) // ( tblWorld -- )
+	p.installThingMethods(state)
+	p.installRequire(state)
+	p.installEnvironment(state)
+
+	p.startLimits(state)
+	err := p.limitError(state.DoString(p.Text))
 	if err != nil {
-		p.Error = err
-	} else {
-		p.state = state
+		p.Error = NewScriptError(err, "", p.Text)
+		state.Close()
+		return p.Error
 	}
-	return err
+	p.state = state
+	if p.closed {
+		p.closeState()
+	}
+	return nil
 }
 
 func printStackTypes(state *lua.State) {
@@ -443,9 +1057,21 @@ func printStackTypes(state *lua.State) {
 }
 
 func (p *ThingProgram) TryToCall(name string, env map[string]interface{}, args ...interface{}) error {
-	if p.Error != nil {
-		// A script that won't compile can't be called, but that counts as trying, so no error.
-		return nil
+	_, err := p.TryToCallFor(name, env, args...)
+	return err
+}
+
+// TryToAsk calls the program's function `name` like TryToCall, answering false only if the function returned false. A program without that function, or whose function returns nothing, answers true.
+func (p *ThingProgram) TryToAsk(name string, env map[string]interface{}, args ...interface{}) (bool, error) {
+	result, err := p.TryToCallFor(name, env, args...)
+	return result != false, err
+}
+
+// TryToCallFor calls the program's function `name` like TryToCall, returning the first value it returned, as read by toValue. A program without that function returns nil. Errors are *ScriptErrors.
+func (p *ThingProgram) TryToCallFor(name string, env map[string]interface{}, args ...interface{}) (interface{}, error) {
+	if ok, err := p.compiled(); !ok {
+		// A script that won't compile can't be called, but that counts as trying, so no error after the first.
+		return nil, err
 	}
 	state := p.state
 	printStackTypes(state)
@@ -465,23 +1091,64 @@ func (p *ThingProgram) TryToCall(name string, env map[string]interface{}, args .
 	if !state.IsFunction(-1) {
 		state.Pop(1) // ( val? -- )
 		// We were unable to find the function, but that counts as trying, so no error.
-		return nil
+		return nil, nil
 	} // ( val? -- func )
 	log.Println("Found our function", name)
 	printStackTypes(state)
 
-	// Put our local global variables in the global table.
-	for name, value := range env { // ( func -- )
-		log.Println("Adding", name, ":", value, "to softcode globals")
-		printStackTypes(state)
+	result, err := p.call(env, args...)
+	if err != nil {
+		return nil, NewScriptError(err, name, p.Text)
+	}
+	return result, nil
+}
+
+// TryToCallRef calls the function with the given registry reference (such as a timer's callback) like TryToCall, describing it as `name` in its errors. If the reference isn't to a function, that counts as trying, so no error.
+func (p *ThingProgram) TryToCallRef(ref int, name string, env map[string]interface{}, args ...interface{}) error {
+	if ok, err := p.compiled(); !ok {
+		return err
+	}
+	state := p.state
+
+	state.RawGeti(lua.LUA_REGISTRYINDEX, ref) // ( -- func? )
+	if !state.IsFunction(-1) {
+		state.Pop(1) // ( val? -- )
+		return nil
+	}
+	_, err := p.call(env, args...)
+	if err != nil {
+		return NewScriptError(err, name, p.Text)
+	}
+	return nil
+}
+
+// call calls the function on top of the stack with the given environment & args, popping it, and returns its first result as TryToCallFor does.
+func (p *ThingProgram) call(env map[string]interface{}, args ...interface{}) (interface{}, error) {
+	state := p.state
 
-		err := pushValue(state, value) // ( func -- func val? )
+	// Make a table of this call's environment, for installEnvironment()'s metatable to find.
+	if len(p.frames) >= MaxCallDepth {
+		state.Pop(1) // ( func -- )
+		return nil, fmt.Errorf("program was called again more than %d times before its first call finished", MaxCallDepth)
+	}
+	state.CreateTable(0, len(env)) // ( func -- func env )
+	for name, value := range env {
+		log.Println("Adding", name, ":", value, "to softcode environment")
+		err := pushValue(state, value) // ( func env -- func env val? )
 		if err != nil {                // if error, pushValue() left the stack at +0
-			log.Println("Error pushing softcode global", name, "onto stack (using nil instead):", err.Error())
-			state.PushNil() // ( -- func val )
-		} // ( func val? -- func val )
-		state.SetGlobal(name) // ( func val -- func )
-	} // ( func -- func )
+			log.Println("Error pushing softcode environment", name, "onto stack (skipping it):", err.Error())
+			continue
+		}
+		state.SetField(-2, name) // ( func env val -- func env )
+	}
+	p.frames = append(p.frames, programFrame{state.Ref(lua.LUA_REGISTRYINDEX), p.Identity}) // ( func env -- func )
+	defer func() {
+		state.Unref(lua.LUA_REGISTRYINDEX, p.frames[len(p.frames)-1].env)
+		p.frames = p.frames[:len(p.frames)-1]
+		if p.closed && len(p.frames) == 0 {
+			p.closeState()
+		}
+	}()
 	printStackTypes(state)
 
 	// Put our args on the stack.
@@ -502,21 +1169,24 @@ func (p *ThingProgram) TryToCall(name string, env map[string]interface{}, args .
 			funcPos, "is no longer our function but a", state.LTypename(funcPos), "??")
 	}
 	log.Println("Calling function at stack pos", funcPos, "with", len(args), "args")
-	err := state.Call(len(args), 0) // ( func -- strErr? )
+	// Calls from inside this one count toward its limits, rather than getting their own.
+	if len(p.frames) == 1 {
+		p.startLimits(state)
+		p.created = 0
+	}
+	err := p.limitError(state.Call(len(args), 1)) // ( func -- result | strErr )
 	log.Println("Whoa back from call!")
 	printStackTypes(state)
 	if err != nil {
 		// Pop the error the pcall (it's actually a lua_pcall() ) left on the stack.
 		state.Pop(1)
+		return nil, err
 	}
 
-	// All the provided environment stuff should use "reserved" names. So it's safe to just clear them out of the global table.
-	for name, _ := range env {
-		state.PushNil()       // ( -- nil )
-		state.SetGlobal(name) // ( nil -- )
-	} // ( -- )
-	log.Println("Cleaned up globals")
-	printStackTypes(state)
-
-	return err
+	result, err := toValue(state, -1)
+	state.Pop(1) // ( result -- )
+	if err != nil {
+		return nil, fmt.Errorf("couldn't use what the program returned: %s", err.Error())
+	}
+	return result, nil
 }
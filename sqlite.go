@@ -0,0 +1,840 @@
+package mess
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jameskeane/bcrypt"
+	"github.com/mattn/go-sqlite3"
+	"log"
+	"strings"
+	"time"
+)
+
+// sqliteIdList is a ThingIdList as stored in SQLite. SQLite has no array columns, so the ids are kept in a TEXT column as a JSON array.
+type sqliteIdList ThingIdList
+
+func (l *sqliteIdList) Scan(src interface{}) error {
+	var asBytes []byte
+	switch v := src.(type) {
+	case []byte:
+		asBytes = v
+	case string:
+		asBytes = []byte(v)
+	default:
+		return errors.New("Scan source was not []byte or string")
+	}
+
+	var ids []ThingId
+	err := json.Unmarshal(asBytes, &ids)
+	if err != nil {
+		return err
+	}
+	if ids == nil {
+		ids = []ThingId{}
+	}
+	(*l) = ids
+	return nil
+}
+
+func (l sqliteIdList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	text, err := json.Marshal([]ThingId(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(text), nil
+}
+
+// sqliteError classifies an error from SQLite as a StoreError.
+func sqliteError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*StoreError); ok {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return &StoreError{StoreNotFound, err}
+	}
+	if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+		return &StoreError{StoreConstraintViolation, err}
+	}
+	return &StoreError{StoreUnavailable, err}
+}
+
+// SqliteWorld is a WorldStore and AccountStore backed by a single SQLite database file, for small messes that don't need a PostgreSQL server.
+type SqliteWorld struct {
+	db *sql.DB
+}
+
+func OpenSqliteDatabase() (*SqliteWorld, error) {
+	db, err := sql.Open("sqlite3", Config.Dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows only one writer at a time, so share one connection instead of failing with "database is locked".
+	db.SetMaxOpenConns(1)
+	return &SqliteWorld{db}, nil
+}
+
+func (w *SqliteWorld) ThingForId(id ThingId) (*Thing, error) {
+	if id == 0 {
+		return nil, notFoundError("there is no thing #0")
+	}
+
+	thing := NewThing()
+	thing.Id = id
+
+	row := w.db.QueryRow("SELECT type, name, creator, created, owner, superuser, wizard, adminlist, allowlist, denylist, parent, tabledata, program FROM thing WHERE id = ?",
+		id)
+	var typeName string
+	var creator sql.NullInt64
+	var owner sql.NullInt64
+	var parent sql.NullInt64
+	var tabledata string
+	var program sql.NullString
+	err := row.Scan(&typeName, &thing.Name, &creator, &thing.Created, &owner,
+		&thing.Superuser, &thing.Wizard, (*sqliteIdList)(&thing.AdminList),
+		(*sqliteIdList)(&thing.AllowList), (*sqliteIdList)(&thing.DenyList),
+		&parent, &tabledata, &program)
+	if err != nil {
+		log.Println("Error finding thing", id, ":", err.Error())
+		return nil, sqliteError(err)
+	}
+	thing.Type = ThingTypeForName(typeName)
+	if creator.Valid {
+		thing.Creator = ThingId(creator.Int64)
+	}
+	if owner.Valid {
+		thing.Owner = ThingId(owner.Int64)
+	}
+	if parent.Valid {
+		thing.Parent = ThingId(parent.Int64)
+	}
+	if program.Valid {
+		thing.Program = LoadProgram(program.String)
+	}
+	thing.Table, err = DecodeTable([]byte(tabledata))
+	if err != nil {
+		log.Println("Error finding table data for thing", id, ":", err.Error())
+		return nil, &StoreError{StoreConstraintViolation, err}
+	}
+
+	// Find thing's contents.
+	if thing.Type.HasContents() {
+		contentRows, err := w.db.Query("SELECT id FROM thing WHERE parent = ?", id)
+		if err != nil {
+			log.Println("Error finding contents", id, ":", err.Error())
+			return nil, sqliteError(err)
+		}
+		defer contentRows.Close()
+		for contentRows.Next() {
+			var childId ThingId
+			if err := contentRows.Scan(&childId); err != nil {
+				log.Println("Error finding contents", id, ":", err.Error())
+				return nil, sqliteError(err)
+			}
+
+			thing.Contents = append(thing.Contents, childId)
+		}
+		if err := contentRows.Err(); err != nil {
+			log.Println("Error finding contents", id, ":", err.Error())
+			return nil, sqliteError(err)
+		}
+	}
+
+	return thing, nil
+}
+
+func (w *SqliteWorld) CreateThing(name string, tt ThingType, creator *Thing, parent *Thing) (*Thing, error) {
+	thing := NewThing()
+	thing.Name = name
+	thing.Type = tt
+	thing.Parent = parent.Id
+
+	var creatorId sql.NullInt64
+	if creator != nil && thing.Type.HasOwner() {
+		creatorId.Int64 = int64(creator.Id)
+		creatorId.Valid = true
+		thing.Creator = creator.Id
+		thing.Owner = creator.Id
+	}
+
+	// SQLite has no RETURNING, so ask for the new row's id & creation time separately.
+	result, err := w.db.Exec("INSERT INTO thing (name, type, creator, owner, parent) VALUES (?, ?, ?, ?, ?)",
+		thing.Name, thing.Type.String(), creatorId, creatorId, thing.Parent)
+	if err != nil {
+		log.Println("Error creating a thing", name, ":", err.Error())
+		return nil, sqliteError(err)
+	}
+	newId, err := result.LastInsertId()
+	if err != nil {
+		log.Println("Error creating a thing", name, ":", err.Error())
+		return nil, sqliteError(err)
+	}
+	thing.Id = ThingId(newId)
+
+	row := w.db.QueryRow("SELECT created FROM thing WHERE id = ?", thing.Id)
+	err = row.Scan(&thing.Created)
+	if err != nil {
+		log.Println("Error creating a thing", name, ":", err.Error())
+		return nil, sqliteError(err)
+	}
+
+	return thing, nil
+}
+
+func (w *SqliteWorld) MoveThing(thing *Thing, target *Thing) error {
+	_, err := w.db.Exec("UPDATE thing SET parent = ? WHERE id = ?",
+		target.Id, thing.Id)
+	if err != nil {
+		log.Println("Error moving a thing", thing.Id, ":", err.Error())
+		return sqliteError(err)
+	}
+	return nil
+}
+
+func (w *SqliteWorld) SaveThing(thing *Thing) error {
+	return saveSqliteThing(w.db, thing)
+}
+
+// SaveThings saves all the things in one transaction.
+func (w *SqliteWorld) SaveThings(things []*Thing) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		log.Println("Couldn't open transaction to save things:", err.Error())
+		return sqliteError(err)
+	}
+	for _, thing := range things {
+		err = saveSqliteThing(tx, thing)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println("Couldn't commit transaction to save things:", err.Error())
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// SetSuperuser makes the thing a superuser or not. Saving a thing never changes that, so this is only for importing worlds.
+func (w *SqliteWorld) SetSuperuser(id ThingId, superuser bool) error {
+	_, err := w.db.Exec("UPDATE thing SET superuser = ? WHERE id = ?", superuser, id)
+	if err != nil {
+		log.Println("Error setting whether thing", id, "is a superuser:", err.Error())
+		return sqliteError(err)
+	}
+	return nil
+}
+
+func saveSqliteThing(db execer, thing *Thing) error {
+	tabletext, err := EncodeTable(thing.Table)
+	if err != nil {
+		log.Println("Error serializing table data for thing", thing.Id, ":", err.Error())
+		return &StoreError{StoreConstraintViolation, err}
+	}
+
+	var parent sql.NullInt64
+	if thing.Parent != 0 {
+		parent.Int64 = int64(thing.Parent)
+		parent.Valid = true
+	}
+	var owner sql.NullInt64
+	if thing.Owner != 0 && thing.Type.HasOwner() {
+		owner.Int64 = int64(thing.Owner)
+		owner.Valid = true
+	}
+	var program sql.NullString
+	if thing.Program != nil {
+		program.String = thing.Program.Text
+		program.Valid = true
+	}
+
+	_, err = db.Exec("UPDATE thing SET name = ?, parent = ?, owner = ?, adminlist = ?, allowlist = ?, denylist = ?, tabledata = ?, program = ?, wizard = ? WHERE id = ?",
+		thing.Name, parent, owner, sqliteIdList(thing.AdminList),
+		sqliteIdList(thing.AllowList), sqliteIdList(thing.DenyList),
+		string(tabletext), program, thing.Wizard, thing.Id)
+	if err != nil {
+		log.Println("Error saving a thing", thing.Id, ":", err.Error())
+		return sqliteError(err)
+	}
+	return nil
+}
+
+func (w *SqliteWorld) DestroyThing(thing *Thing) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		log.Println("Couldn't open transaction to destroy thing", thing.Id, ":", err.Error())
+		return sqliteError(err)
+	}
+
+	var numAccounts int
+	row := tx.QueryRow("SELECT COUNT(*) FROM account WHERE character = ?", thing.Id)
+	err = row.Scan(&numAccounts)
+	if err != nil {
+		log.Println("Error destroying thing", thing.Id, ":", err.Error())
+		tx.Rollback()
+		return sqliteError(err)
+	}
+	if numAccounts > 0 {
+		log.Println("Refusing to destroy thing", thing.Id, "as it's the character of an account")
+		tx.Rollback()
+		return &StoreError{StoreConstraintViolation, fmt.Errorf("%s is the character of an account", thing.Name)}
+	}
+
+	// Actions go with the thing, but its other contents are rescued.
+	_, err = tx.Exec("UPDATE thing SET parent = ? WHERE parent = ? AND type <> 'action'",
+		thing.RecycleHome(), thing.Id)
+	if err != nil {
+		log.Println("Error moving contents of thing", thing.Id, "to destroy it:", err.Error())
+		tx.Rollback()
+		return sqliteError(err)
+	}
+
+	// The access lists are JSON text, so find the ones that mention the thing here in Go.
+	type accessLists struct {
+		id                             ThingId
+		adminList, allowList, denyList sqliteIdList
+	}
+	var changed []accessLists
+	rows, err := tx.Query("SELECT id, adminlist, allowlist, denylist FROM thing")
+	if err != nil {
+		log.Println("Error destroying thing", thing.Id, ":", err.Error())
+		tx.Rollback()
+		return sqliteError(err)
+	}
+	for rows.Next() {
+		var lists accessLists
+		err = rows.Scan(&lists.id, &lists.adminList, &lists.allowList, &lists.denyList)
+		if err != nil {
+			break
+		}
+		if ThingIdList(lists.adminList).Contains(thing.Id) || ThingIdList(lists.allowList).Contains(thing.Id) || ThingIdList(lists.denyList).Contains(thing.Id) {
+			changed = append(changed, lists)
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	if err != nil {
+		log.Println("Error destroying thing", thing.Id, ":", err.Error())
+		tx.Rollback()
+		return sqliteError(err)
+	}
+	for _, lists := range changed {
+		_, err = tx.Exec("UPDATE thing SET adminlist = ?, allowlist = ?, denylist = ? WHERE id = ?",
+			sqliteIdList(ThingIdList(lists.adminList).Without(thing.Id)),
+			sqliteIdList(ThingIdList(lists.allowList).Without(thing.Id)),
+			sqliteIdList(ThingIdList(lists.denyList).Without(thing.Id)),
+			lists.id)
+		if err != nil {
+			log.Println("Error destroying thing", thing.Id, ":", err.Error())
+			tx.Rollback()
+			return sqliteError(err)
+		}
+	}
+
+	actions, err := childActionsInTx(tx, "SELECT id FROM thing WHERE parent = ? AND type = 'action'", thing.Id)
+	if err == nil {
+		err = removeTableRefsInTx(tx, `SELECT id, tabledata FROM thing WHERE tabledata LIKE '%"$thing"%' OR tabledata LIKE '%"target"%'`,
+			"UPDATE thing SET tabledata = ? WHERE id = ?", append(ThingIdList{thing.Id}, actions...))
+	}
+	if err != nil {
+		log.Println("Error removing references to thing", thing.Id, "to destroy it:", err.Error())
+		tx.Rollback()
+		return sqliteError(err)
+	}
+
+	statements := []string{
+		"DELETE FROM thing_revision WHERE thing IN (SELECT id FROM thing WHERE parent = ? AND type = 'action')",
+		"DELETE FROM timer WHERE thing IN (SELECT id FROM thing WHERE parent = ? AND type = 'action')",
+		"DELETE FROM script_error WHERE thing IN (SELECT id FROM thing WHERE parent = ? AND type = 'action')",
+		"DELETE FROM thing WHERE parent = ? AND type = 'action'",
+		"UPDATE thing SET creator = NULL WHERE creator = ?",
+		"UPDATE thing SET owner = NULL WHERE owner = ?",
+		"UPDATE thing_revision SET editor = NULL WHERE editor = ?",
+		"UPDATE script_error SET player = NULL WHERE player = ?",
+		"DELETE FROM thing_revision WHERE thing = ?",
+		"DELETE FROM timer WHERE thing = ?",
+		"DELETE FROM script_error WHERE thing = ?",
+		"DELETE FROM thing WHERE id = ?",
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(statement, thing.Id)
+		if err != nil {
+			log.Println("Error destroying thing", thing.Id, ":", err.Error())
+			tx.Rollback()
+			return sqliteError(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("Couldn't commit transaction to destroy thing", thing.Id, ":", err.Error())
+		tx.Rollback()
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// SaveRevisions adds the revisions to their things' histories, all in one transaction.
+func (w *SqliteWorld) SaveRevisions(revs []*ThingRevision) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		log.Println("Couldn't open transaction to save revisions:", err.Error())
+		return sqliteError(err)
+	}
+	for _, rev := range revs {
+		tabletext, err := EncodeTable(rev.Table)
+		if err != nil {
+			log.Println("Error serializing table data for revision of thing", rev.Thing, ":", err.Error())
+			tx.Rollback()
+			return &StoreError{StoreConstraintViolation, err}
+		}
+
+		var editor, owner sql.NullInt64
+		if rev.Editor != 0 {
+			editor.Int64 = int64(rev.Editor)
+			editor.Valid = true
+		}
+		if rev.Owner != 0 {
+			owner.Int64 = int64(rev.Owner)
+			owner.Valid = true
+		}
+		var program sql.NullString
+		if rev.Program != "" {
+			program.String = rev.Program
+			program.Valid = true
+		}
+
+		result, err := tx.Exec("INSERT INTO thing_revision (thing, editor, created, name, owner, adminlist, allowlist, denylist, tabledata, program) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			rev.Thing, editor, rev.Created, rev.Name, owner, sqliteIdList(rev.AdminList),
+			sqliteIdList(rev.AllowList), sqliteIdList(rev.DenyList),
+			string(tabletext), program)
+		if err == nil {
+			rev.Id, err = result.LastInsertId()
+		}
+		if err != nil {
+			log.Println("Error saving revision of thing", rev.Thing, ":", err.Error())
+			tx.Rollback()
+			return sqliteError(err)
+		}
+	}
+	err = pruneRevisionsInTx(tx, "DELETE FROM thing_revision WHERE thing = ?1 AND id <= (SELECT id FROM thing_revision WHERE thing = ?1 ORDER BY id DESC LIMIT 1 OFFSET ?2)", revs)
+	if err != nil {
+		log.Println("Error forgetting old revisions:", err.Error())
+		tx.Rollback()
+		return sqliteError(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println("Couldn't commit transaction to save revisions:", err.Error())
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// ThingRevisions finds the history of the thing with the given id, newest first.
+func (w *SqliteWorld) ThingRevisions(id ThingId) ([]*ThingRevision, error) {
+	rows, err := w.db.Query("SELECT id, editor, created, name, owner, adminlist, allowlist, denylist, tabledata, program FROM thing_revision WHERE thing = ? ORDER BY id DESC",
+		id)
+	if err != nil {
+		log.Println("Error finding revisions of thing", id, ":", err.Error())
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var revs []*ThingRevision
+	for rows.Next() {
+		rev := &ThingRevision{Thing: id}
+		var editor, owner sql.NullInt64
+		var tabledata string
+		var program sql.NullString
+		err = rows.Scan(&rev.Id, &editor, &rev.Created, &rev.Name, &owner,
+			(*sqliteIdList)(&rev.AdminList), (*sqliteIdList)(&rev.AllowList),
+			(*sqliteIdList)(&rev.DenyList), &tabledata, &program)
+		if err != nil {
+			log.Println("Error finding revisions of thing", id, ":", err.Error())
+			return nil, sqliteError(err)
+		}
+		rev.Editor = ThingId(editor.Int64)
+		rev.Owner = ThingId(owner.Int64)
+		rev.Program = program.String
+		rev.Table, err = DecodeTable([]byte(tabledata))
+		if err != nil {
+			log.Println("Error finding table data for revision", rev.Id, ":", err.Error())
+			return nil, &StoreError{StoreConstraintViolation, err}
+		}
+		revs = append(revs, rev)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Println("Error finding revisions of thing", id, ":", err.Error())
+		return nil, sqliteError(err)
+	}
+	return revs, nil
+}
+
+// SaveTimer adds the timer to the saved timers, or updates it if it's already saved.
+func (w *SqliteWorld) SaveTimer(timer *Timer) error {
+	var owner sql.NullInt64
+	if timer.Owner != 0 {
+		owner.Int64 = int64(timer.Owner)
+		owner.Valid = true
+	}
+	intervalMillis := int64(timer.Interval / time.Millisecond)
+
+	_, err := w.db.Exec("INSERT OR REPLACE INTO timer (id, thing, owner, callback, due, interval_ms) VALUES (?, ?, ?, ?, ?, ?)",
+		timer.Id, timer.Thing, owner, timer.Function, timer.Due, intervalMillis)
+	if err != nil {
+		log.Println("Error saving timer", timer.Id, ":", err.Error())
+		return sqliteError(err)
+	}
+	return nil
+}
+
+func (w *SqliteWorld) DeleteTimer(id int64) error {
+	_, err := w.db.Exec("DELETE FROM timer WHERE id = ?", id)
+	if err != nil {
+		log.Println("Error deleting timer", id, ":", err.Error())
+		return sqliteError(err)
+	}
+	return nil
+}
+
+func (w *SqliteWorld) AllTimers() ([]*Timer, error) {
+	rows, err := w.db.Query("SELECT id, thing, owner, callback, due, interval_ms FROM timer ORDER BY id")
+	if err != nil {
+		log.Println("Error listing timers:", err.Error())
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var timers []*Timer
+	for rows.Next() {
+		timer := &Timer{}
+		var owner sql.NullInt64
+		var intervalMillis int64
+		err = rows.Scan(&timer.Id, &timer.Thing, &owner, &timer.Function, &timer.Due, &intervalMillis)
+		if err != nil {
+			log.Println("Error listing timers:", err.Error())
+			return nil, sqliteError(err)
+		}
+		timer.Owner = ThingId(owner.Int64)
+		timer.Interval = time.Duration(intervalMillis) * time.Millisecond
+		timers = append(timers, timer)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Println("Error listing timers:", err.Error())
+		return nil, sqliteError(err)
+	}
+	return timers, nil
+}
+
+// SaveScriptError adds the error to its thing's error log, setting its Id, and forgets the thing's oldest errors beyond MaxScriptErrors.
+func (w *SqliteWorld) SaveScriptError(scriptErr *ScriptError) error {
+	var player sql.NullInt64
+	if scriptErr.Player != 0 {
+		player.Int64 = int64(scriptErr.Player)
+		player.Valid = true
+	}
+
+	result, err := w.db.Exec("INSERT INTO script_error (thing, handler, line, library, message, traceback, player, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		scriptErr.Thing, scriptErr.Function, scriptErr.Line, scriptErr.Library, scriptErr.Message, strings.Join(scriptErr.Traceback, "\n"), player, scriptErr.Created)
+	if err == nil {
+		scriptErr.Id, err = result.LastInsertId()
+	}
+	if err == nil {
+		_, err = w.db.Exec("DELETE FROM script_error WHERE thing = ? AND id NOT IN (SELECT id FROM script_error WHERE thing = ? ORDER BY id DESC LIMIT ?)",
+			scriptErr.Thing, scriptErr.Thing, MaxScriptErrors)
+	}
+	if err != nil {
+		log.Println("Error saving script error for thing", scriptErr.Thing, ":", err.Error())
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// ScriptErrors finds the error log of the thing with the given id, newest first.
+func (w *SqliteWorld) ScriptErrors(id ThingId) ([]*ScriptError, error) {
+	rows, err := w.db.Query("SELECT id, thing, handler, line, library, message, traceback, player, created FROM script_error WHERE thing = ? ORDER BY id DESC", id)
+	if err != nil {
+		log.Println("Error finding script errors of thing", id, ":", err.Error())
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var scriptErrs []*ScriptError
+	for rows.Next() {
+		scriptErr := &ScriptError{}
+		var traceback string
+		var player sql.NullInt64
+		err = rows.Scan(&scriptErr.Id, &scriptErr.Thing, &scriptErr.Function, &scriptErr.Line, &scriptErr.Library, &scriptErr.Message, &traceback, &player, &scriptErr.Created)
+		if err != nil {
+			log.Println("Error finding script errors of thing", id, ":", err.Error())
+			return nil, sqliteError(err)
+		}
+		if traceback != "" {
+			scriptErr.Traceback = strings.Split(traceback, "\n")
+		}
+		scriptErr.Player = ThingId(player.Int64)
+		scriptErrs = append(scriptErrs, scriptErr)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Println("Error finding script errors of thing", id, ":", err.Error())
+		return nil, sqliteError(err)
+	}
+	return scriptErrs, nil
+}
+
+func (w *SqliteWorld) ClearScriptErrors(id ThingId) error {
+	_, err := w.db.Exec("DELETE FROM script_error WHERE thing = ?", id)
+	if err != nil {
+		log.Println("Error clearing script errors of thing", id, ":", err.Error())
+		return sqliteError(err)
+	}
+	return nil
+}
+
+func (w *SqliteWorld) AllThingIds() ([]ThingId, error) {
+	rows, err := w.db.Query("SELECT id FROM thing ORDER BY id")
+	if err != nil {
+		log.Println("Error listing things:", err.Error())
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var ids []ThingId
+	for rows.Next() {
+		var id ThingId
+		err = rows.Scan(&id)
+		if err != nil {
+			log.Println("Error listing things:", err.Error())
+			return nil, sqliteError(err)
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Println("Error listing things:", err.Error())
+		return nil, sqliteError(err)
+	}
+	return ids, nil
+}
+
+func (w *SqliteWorld) FindThings(q *ThingQuery) ([]ThingId, error) {
+	search := &sqlSearch{placeholder: func(n int) string { return "?" }}
+	search.addCommon(q)
+	if q.TableKey != "" {
+		// Check() made sure the key has no quotes to escape.
+		path := fmt.Sprintf(`$."%s"`, q.TableKey)
+		switch value := q.TableValue.(type) {
+		case nil:
+			search.add("json_type(tabledata, %s) IS NOT NULL", path)
+		case string:
+			search.add("json_type(tabledata, %s) = 'text' AND json_extract(tabledata, %s) = %s", path, path, value)
+		case float64:
+			search.add("json_type(tabledata, %s) IN ('integer', 'real') AND json_extract(tabledata, %s) = %s", path, path, value)
+		case bool:
+			search.add("json_type(tabledata, %s) = %s", path, fmt.Sprintf("%t", value))
+		case ThingId:
+			search.add("json_extract(tabledata, %s) = %s", fmt.Sprintf(`%s."%s"`, path, ThingRefKey), int64(value))
+		}
+	}
+
+	rows, err := w.db.Query(search.query(q.ResultLimit()), search.args...)
+	if err != nil {
+		log.Println("Error finding things:", err.Error())
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var ids []ThingId
+	for rows.Next() {
+		var id ThingId
+		err = rows.Scan(&id)
+		if err != nil {
+			log.Println("Error finding things:", err.Error())
+			return nil, sqliteError(err)
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Println("Error finding things:", err.Error())
+		return nil, sqliteError(err)
+	}
+	return ids, nil
+}
+
+func (w *SqliteWorld) GetAccount(name string) (*Account, error) {
+	acc := &Account{}
+	row := w.db.QueryRow("SELECT loginname, character, created FROM account WHERE loginname = ?",
+		name)
+	err := row.Scan(&acc.LoginName, &acc.Character, &acc.Created)
+	if err != nil {
+		log.Println("Error loading account with name", name, ":", err)
+		return nil, sqliteError(err)
+	}
+	return acc, nil
+}
+
+func (w *SqliteWorld) AccountForLogin(name, password string) (*Account, error) {
+	acc := &Account{}
+	row := w.db.QueryRow("SELECT loginname, passwordhash, character, created FROM account WHERE loginname = ?",
+		name)
+	err := row.Scan(&acc.LoginName, &acc.PasswordHash, &acc.Character, &acc.Created)
+	// TODO: oh look there are timing attacks wheeeeeeee
+	if err != nil {
+		log.Println("Error loading account with name", name, ":", err.Error())
+		err = sqliteError(err)
+		if StoreFailureOf(err) == StoreNotFound {
+			return nil, errBadLogin
+		}
+		return nil, err
+	}
+
+	if !bcrypt.Match(password, acc.PasswordHash) {
+		log.Println("Bad login attempt for account", name)
+		return nil, errBadLogin
+	}
+
+	return acc, nil
+}
+
+func (w *SqliteWorld) CreateAccount(name, passwordHash string) (*Account, error) {
+	// Check for the name first, so we don't make a character we won't use.
+	_, err := w.GetAccount(name)
+	if err == nil {
+		return nil, &StoreError{StoreDuplicateLogin, errors.New("an account with that name already exists")}
+	}
+	if StoreFailureOf(err) != StoreNotFound {
+		return nil, err
+	}
+
+	// TODO: Config setting for where to start new players?
+	origin, err := World.ThingForId(1)
+	if err != nil {
+		log.Println("Couldn't find starting place to create an account:", err.Error())
+		return nil, err
+	}
+	char, err := World.CreateThing(name, PlayerThing, nil, origin)
+	if err != nil {
+		log.Println("Couldn't create character to create an account:", err.Error())
+		return nil, err
+	}
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		log.Println("Couldn't open transaction to create an account:", err.Error())
+		World.DestroyThing(char)
+		return nil, sqliteError(err)
+	}
+
+	acc := &Account{name, passwordHash, char.Id, time.Unix(0, 0)}
+
+	_, err = tx.Exec("INSERT INTO account (loginname, passwordhash, character) VALUES (?, ?, ?)",
+		name, passwordHash, acc.Character)
+	if err != nil {
+		log.Println("Couldn't create new account:", err.Error())
+		tx.Rollback()
+		World.DestroyThing(char)
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return nil, &StoreError{StoreDuplicateLogin, err}
+		}
+		return nil, sqliteError(err)
+	}
+
+	row := tx.QueryRow("SELECT created FROM account WHERE loginname = ?", name)
+	err = row.Scan(&acc.Created)
+	if err != nil {
+		log.Println("Couldn't create new account:", err.Error())
+		tx.Rollback()
+		World.DestroyThing(char)
+		return nil, sqliteError(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("Couldn't commit transaction to create new account:", err.Error())
+		tx.Rollback()
+		World.DestroyThing(char)
+		return nil, sqliteError(err)
+	}
+
+	return acc, nil
+}
+
+func (w *SqliteWorld) AllAccounts() ([]*Account, error) {
+	rows, err := w.db.Query("SELECT loginname, character, created FROM account ORDER BY loginname")
+	if err != nil {
+		log.Println("Error listing accounts:", err.Error())
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var accounts []*Account
+	for rows.Next() {
+		acc := &Account{}
+		err = rows.Scan(&acc.LoginName, &acc.Character, &acc.Created)
+		if err != nil {
+			log.Println("Error listing accounts:", err.Error())
+			return nil, sqliteError(err)
+		}
+		accounts = append(accounts, acc)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Println("Error listing accounts:", err.Error())
+		return nil, sqliteError(err)
+	}
+	return accounts, nil
+}
+
+func (w *SqliteWorld) ImportAccount(name, password string, character ThingId) (*Account, error) {
+	passwordHash, err := bcrypt.Hash(password)
+	if err != nil {
+		log.Println("Couldn't hash password to import an account:", err.Error())
+		return nil, &StoreError{StoreUnavailable, err}
+	}
+
+	_, err = w.db.Exec("INSERT INTO account (loginname, passwordhash, character) VALUES (?, ?, ?)",
+		name, passwordHash, character)
+	if err != nil {
+		log.Println("Couldn't import account", name, ":", err.Error())
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return nil, &StoreError{StoreDuplicateLogin, err}
+		}
+		return nil, sqliteError(err)
+	}
+
+	acc := &Account{name, passwordHash, character, time.Unix(0, 0)}
+	row := w.db.QueryRow("SELECT created FROM account WHERE loginname = ?", name)
+	err = row.Scan(&acc.Created)
+	if err != nil {
+		log.Println("Couldn't import account", name, ":", err.Error())
+		return nil, sqliteError(err)
+	}
+	return acc, nil
+}
+
+// DeleteAccount removes the named account, leaving its character. It's for undoing an import that failed.
+func (w *SqliteWorld) DeleteAccount(name string) error {
+	_, err := w.db.Exec("DELETE FROM account WHERE loginname = ?", name)
+	if err != nil {
+		log.Println("Couldn't delete account", name, ":", err.Error())
+		return sqliteError(err)
+	}
+	return nil
+}
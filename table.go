@@ -0,0 +1,122 @@
+package mess
+
+import (
+	"encoding/json"
+)
+
+// ThingRefKey is the key of the JSON objects that stand for things in table data, as in {"$thing": 12}. In a loaded Table, those objects are ThingIds instead.
+const ThingRefKey = "$thing"
+
+// EncodeTable converts a thing's table data to JSON, writing the ThingIds in it as {"$thing": id} references.
+func EncodeTable(table map[string]interface{}) ([]byte, error) {
+	return json.Marshal(encodeThingRefs(table))
+}
+
+// DecodeTable parses a thing's table data from JSON, turning its {"$thing": id} references back into ThingIds.
+func DecodeTable(data []byte) (map[string]interface{}, error) {
+	var table map[string]interface{}
+	err := json.Unmarshal(data, &table)
+	if err != nil {
+		return nil, err
+	}
+	if table == nil {
+		table = make(map[string]interface{})
+	}
+
+	decodeThingRefs(table)
+	return table, nil
+}
+
+func encodeThingRefs(value interface{}) interface{} {
+	switch v := value.(type) {
+	case ThingId:
+		return map[string]interface{}{ThingRefKey: int64(v)}
+	case *Thing:
+		return map[string]interface{}{ThingRefKey: int64(v.Id)}
+	case map[string]interface{}:
+		encoded := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			encoded[key] = encodeThingRefs(item)
+		}
+		return encoded
+	case []interface{}:
+		encoded := make([]interface{}, len(v))
+		for i, item := range v {
+			encoded[i] = encodeThingRefs(item)
+		}
+		return encoded
+	}
+	return value
+}
+
+// decodeThingRefs replaces the {"$thing": id} references in value with ThingIds. Tables & lists are changed in place.
+func decodeThingRefs(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if id, ok := v[ThingRefKey].(float64); ok {
+				return ThingId(id)
+			}
+		}
+		for key, item := range v {
+			v[key] = decodeThingRefs(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = decodeThingRefs(item)
+		}
+	}
+	return value
+}
+
+// TableThingRefs finds all the things referred to in table data.
+func TableThingRefs(value interface{}) (ids []ThingId) {
+	switch v := value.(type) {
+	case ThingId:
+		ids = append(ids, v)
+	case map[string]interface{}:
+		for _, item := range v {
+			ids = append(ids, TableThingRefs(item)...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			ids = append(ids, TableThingRefs(item)...)
+		}
+	}
+	return
+}
+
+// RemoveThingRefs takes references to the thing with the given id out of table data, reporting whether there were any. Keys that referred to the thing are deleted, while list items that did become nil, so the rest of the list keeps its places. An older action's target, which is a plain number (see ActionTarget), counts as a reference too.
+func RemoveThingRefs(value interface{}, id ThingId) (removed bool) {
+	if table, ok := value.(map[string]interface{}); ok {
+		if target, ok := table["target"].(float64); ok && ThingId(target) == id {
+			delete(table, "target")
+			removed = true
+		}
+	}
+	return removeThingRefs(value, id) || removed
+}
+
+func removeThingRefs(value interface{}, id ThingId) (removed bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if ref, ok := item.(ThingId); ok && ref == id {
+				delete(v, key)
+				removed = true
+			} else if removeThingRefs(item, id) {
+				removed = true
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			if ref, ok := item.(ThingId); ok && ref == id {
+				v[i] = nil
+				removed = true
+			} else if removeThingRefs(item, id) {
+				removed = true
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,306 @@
+package mess
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aarzilli/golua/lua"
+	"log"
+	"sort"
+	"time"
+)
+
+// MinTimerInterval is how often a repeating timer can be called at most.
+const MinTimerInterval = time.Second
+
+// timerTick is how often the scheduler looks for timers that are due.
+const timerTick = 250 * time.Millisecond
+
+// Timer is a call to a thing's program scheduled with world.after or world.every.
+type Timer struct {
+	Id    int64
+	Thing ThingId
+	// Owner is whose quota the timer counts against: the owner of the thing when it was scheduled.
+	Owner ThingId
+	// Function is the name of the program's function to call. Timers for named functions are saved, so they survive restarts; ones for anonymous functions last only as long as the program that made them, so their things are kept in memory until they're done.
+	Function string
+	Due      time.Time
+	// Interval is how often the timer repeats, or 0 if it's called only once.
+	Interval time.Duration
+
+	// program & ref are the program & registry reference of an anonymous function.
+	program *ThingProgram
+	ref     int
+}
+
+// Saved is whether the timer is kept in the world store.
+func (t *Timer) Saved() bool {
+	return t.Function != ""
+}
+
+// Scheduler keeps the timers programs have scheduled and calls them when they're due. Like the things it calls, it must only be used with the world locked (see WithWorld).
+type Scheduler struct {
+	timers map[int64]*Timer
+	lastId int64
+}
+
+// Timers is the scheduler for the running mess.
+var Timers = NewScheduler()
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{timers: make(map[int64]*Timer)}
+}
+
+// TimerQuota is how many timers each owner can have scheduled at once, from the Config. Superusers' things have no quota.
+func TimerQuota() int {
+	if Config.TimerQuota == 0 {
+		return 20
+	}
+	return Config.TimerQuota
+}
+
+// Load adds the timers saved in the World, such as when the server starts.
+func (s *Scheduler) Load() error {
+	timers, err := World.AllTimers()
+	if err != nil {
+		return err
+	}
+	for _, timer := range timers {
+		s.timers[timer.Id] = timer
+		if timer.Id > s.lastId {
+			s.lastId = timer.Id
+		}
+	}
+	log.Println("Loaded", len(timers), "timers")
+	return nil
+}
+
+// Schedule adds a timer for the thing's program, calling its function named `function` (or the function with registry reference ref, if function is "") after delay, and then every interval if interval isn't 0.
+func (s *Scheduler) Schedule(thing *Thing, function string, ref int, delay, interval time.Duration) (*Timer, error) {
+	if delay < 0 {
+		delay = 0
+	}
+	if interval != 0 && interval < MinTimerInterval {
+		return nil, fmt.Errorf("timers can't repeat more often than every %s", MinTimerInterval)
+	}
+
+	owner := thing.PlayerId()
+	if ownerThing := GetThing(owner); ownerThing == nil || !ownerThing.Superuser {
+		if s.CountFor(owner) >= TimerQuota() {
+			return nil, fmt.Errorf("you already have %d timers scheduled, which is as many as you can", TimerQuota())
+		}
+	}
+
+	s.lastId++
+	timer := &Timer{
+		Id:       s.lastId,
+		Thing:    thing.Id,
+		Owner:    owner,
+		Function: function,
+		Due:      time.Now().UTC().Add(delay),
+		Interval: interval,
+	}
+	if function == "" {
+		timer.program = thing.Program
+		timer.ref = ref
+		timer.program.timers++
+	} else {
+		err := World.SaveTimer(timer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.timers[timer.Id] = timer
+	return timer, nil
+}
+
+// CountFor is how many timers the owner has scheduled.
+func (s *Scheduler) CountFor(owner ThingId) int {
+	count := 0
+	for _, timer := range s.timers {
+		if timer.Owner == owner {
+			count++
+		}
+	}
+	return count
+}
+
+// Cancel removes the thing's timer with the given id, returning whether there was one to cancel.
+func (s *Scheduler) Cancel(thingId ThingId, id int64) bool {
+	timer, ok := s.timers[id]
+	if !ok || timer.Thing != thingId {
+		return false
+	}
+	s.remove(timer)
+	return true
+}
+
+func (s *Scheduler) remove(timer *Timer) {
+	delete(s.timers, timer.Id)
+	if timer.Saved() {
+		err := World.DeleteTimer(timer.Id)
+		if err != nil {
+			log.Println("Error deleting timer", timer.Id, ":", err.Error())
+		}
+	} else if timer.program != nil {
+		timer.program.timers--
+		if timer.program.state != nil {
+			timer.program.state.Unref(lua.LUA_REGISTRYINDEX, timer.ref)
+		}
+	}
+}
+
+// hasTimers reports whether the program has anonymous timers waiting, so its thing mustn't be forgotten (and its program closed) until they're done.
+func (p *ThingProgram) hasTimers() bool {
+	return p != nil && p.timers > 0
+}
+
+type timersByDue []*Timer
+
+func (l timersByDue) Len() int           { return len(l) }
+func (l timersByDue) Less(i, j int) bool { return l[i].Due.Before(l[j].Due) }
+func (l timersByDue) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// RunDue calls the timers that are due by now, earliest first. Timers that repeat are rescheduled from now, so a mess that was down doesn't call them over & over to catch up.
+func (s *Scheduler) RunDue(now time.Time) {
+	var due []*Timer
+	for _, timer := range s.timers {
+		if !timer.Due.After(now) {
+			due = append(due, timer)
+		}
+	}
+	sort.Sort(timersByDue(due))
+
+	for _, timer := range due {
+		if _, ok := s.timers[timer.Id]; !ok {
+			// An earlier timer's call cancelled this one.
+			continue
+		}
+
+		thing := GetThing(timer.Thing)
+		if thing == nil || (!timer.Saved() && thing.Program != timer.program) {
+			// The thing is gone, or its program has changed since it scheduled this timer.
+			s.remove(timer)
+			continue
+		}
+
+		if timer.Interval == 0 {
+			// Remove it before calling, so the function can't cancel it again.
+			delete(s.timers, timer.Id)
+			if timer.Saved() {
+				if err := World.DeleteTimer(timer.Id); err != nil {
+					log.Println("Error deleting timer", timer.Id, ":", err.Error())
+				}
+			} else {
+				timer.program.timers--
+			}
+		} else {
+			timer.Due = timer.Due.Add(timer.Interval)
+			if !timer.Due.After(now) {
+				timer.Due = now.Add(timer.Interval)
+			}
+			if timer.Saved() {
+				if err := World.SaveTimer(timer); err != nil {
+					log.Println("Error saving timer", timer.Id, ":", err.Error())
+				}
+			}
+		}
+
+		thing.callTimer(timer)
+
+		if timer.Interval == 0 && !timer.Saved() && thing.Program != nil && thing.Program.state != nil && thing.Program == timer.program {
+			thing.Program.state.Unref(lua.LUA_REGISTRYINDEX, timer.ref)
+		}
+	}
+}
+
+// KeepRunning calls timers as they come due, forever.
+func (s *Scheduler) KeepRunning() {
+	for now := range time.Tick(timerTick) {
+		WithWorld(func() {
+			s.RunDue(now.UTC())
+		})
+	}
+}
+
+// callTimer calls the timer's function in the thing's program, with the thing as `me` and the timer's id as its argument.
+func (thing *Thing) callTimer(timer *Timer) {
+	prog := thing.Program
+	if prog == nil {
+		return
+	}
+
+	env := map[string]interface{}{
+		"me":     thing.Id,
+		"here":   thing.Parent,
+		"target": thing.Id,
+	}
+	if timer.Saved() {
+		thing.TryToCall(timer.Function, env, float64(timer.Id))
+		return
+	}
+
+	prog.Thing = thing.Id
+	prog.Identity = thing.ProgramIdentityFor(0)
+	prog.Limits = thing.ProgramLimits()
+	err := prog.TryToCallRef(timer.ref, fmt.Sprintf("timer %d", timer.Id), env, float64(timer.Id))
+	if err != nil {
+		thing.reportProgramError(err, env)
+	}
+}
+
+// installTimers adds world.after, world.every & world.cancel to the `world` table on top of the stack, for scheduling calls to the program's functions.
+func (p *ThingProgram) installTimers(state *lua.State) {
+	schedule := func(repeat bool) lua.LuaGoFunction {
+		return func(state *lua.State) int {
+			// ( seconds func|name -- )
+			seconds := state.CheckNumber(1)
+			delay := time.Duration(seconds * float64(time.Second))
+
+			function := ""
+			ref := 0
+			if state.IsFunction(2) {
+				state.PushValue(2)                     // ( seconds func -- seconds func func )
+				ref = state.Ref(lua.LUA_REGISTRYINDEX) // ( seconds func func -- seconds func )
+			} else {
+				function = state.CheckString(2)
+			}
+
+			thing := GetThing(p.Thing)
+			var err error
+			var timer *Timer
+			if thing == nil || thing.Program != p {
+				err = errors.New("only a thing's current program can schedule timers")
+			} else {
+				var interval time.Duration
+				if repeat {
+					interval = delay
+				}
+				timer, err = Timers.Schedule(thing, function, ref, delay, interval)
+			}
+			if err != nil {
+				if function == "" {
+					state.Unref(lua.LUA_REGISTRYINDEX, ref)
+				}
+				state.PushNil()
+				state.PushString(err.Error())
+				return 2
+			}
+
+			state.PushNumber(float64(timer.Id))
+			return 1
+		}
+	}
+
+	state.PushGoClosure(schedule(false))
+	state.SetField(-2, "after")
+	state.PushGoClosure(schedule(true))
+	state.SetField(-2, "every")
+
+	state.PushGoClosure(func(state *lua.State) int {
+		// ( handle -- )
+		id := int64(state.CheckNumber(1))
+		state.PushBoolean(Timers.Cancel(p.Thing, id))
+		return 1
+	})
+	state.SetField(-2, "cancel")
+}
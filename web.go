@@ -26,18 +26,39 @@ const (
 var store *sessions.CookieStore
 var getTemplate func(string) *template.Template
 
-func AccountForRequest(w http.ResponseWriter, r *http.Request) *Account {
+// AccountForRequest finds the account signed in for the request. If no one is signed in, both the account & error are nil.
+func AccountForRequest(w http.ResponseWriter, r *http.Request) (*Account, error) {
 	session, _ := store.Get(r, "session")
 	accountNameValue, ok := session.Values["name"]
 	if !ok {
-		return nil
+		return nil, nil
 	}
 	accountName, ok := accountNameValue.(string)
 	if !ok {
-		return nil
+		return nil, nil
 	}
 
-	return Accounts.GetAccount(accountName)
+	acc, err := Accounts.GetAccount(accountName)
+	if StoreFailureOf(err) == StoreNotFound {
+		// The account is gone, so no one is signed in anymore.
+		return nil, nil
+	}
+	return acc, err
+}
+
+// StoreErrorStatus is the HTTP status for an error from the World or Accounts.
+func StoreErrorStatus(err error) int {
+	switch StoreFailureOf(err) {
+	case StoreNotFound:
+		return http.StatusNotFound
+	case StoreDuplicateLogin, StoreConstraintViolation:
+		return http.StatusConflict
+	}
+	return http.StatusServiceUnavailable
+}
+
+func StoreErrorResponse(w http.ResponseWriter, err error) {
+	http.Error(w, StoreErrorMessage(err), StoreErrorStatus(err))
 }
 
 func SetAccountForRequest(w http.ResponseWriter, r *http.Request, acc *Account) {
@@ -53,7 +74,7 @@ func SetAccountForRequest(w http.ResponseWriter, r *http.Request, acc *Account)
 func RenderTemplate(w http.ResponseWriter, r *http.Request, templateName string, templateContext map[string]interface{}) {
 	var paletteItems []*Thing
 	for i := 0; i < 10; i++ {
-		thing := World.ThingForId(ThingId(i))
+		thing := GetThing(ThingId(i))
 		if thing != nil {
 			paletteItems = append(paletteItems, thing)
 		}
@@ -83,7 +104,11 @@ func RenderTemplate(w http.ResponseWriter, r *http.Request, templateName string,
 
 func RequireAccount(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		acc := AccountForRequest(w, r)
+		acc, err := AccountForRequest(w, r)
+		if err != nil {
+			StoreErrorResponse(w, err)
+			return
+		}
 		if acc == nil {
 			v := url.Values{}
 			v.Set("next", r.URL.RequestURI())
@@ -97,7 +122,9 @@ func RequireAccount(h http.Handler) http.Handler {
 		}
 
 		context.Set(r, ContextKeyAccount, acc)
-		h.ServeHTTP(w, r)
+		WithWorld(func() {
+			h.ServeHTTP(w, r)
+		})
 	})
 }
 
@@ -106,7 +133,11 @@ func RequireAccountFunc(f func(w http.ResponseWriter, r *http.Request)) http.Han
 }
 
 func WebSignIn(w http.ResponseWriter, r *http.Request) {
-	acc := AccountForRequest(w, r)
+	acc, err := AccountForRequest(w, r)
+	if err != nil {
+		StoreErrorResponse(w, err)
+		return
+	}
 	if acc != nil {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
@@ -116,7 +147,11 @@ func WebSignIn(w http.ResponseWriter, r *http.Request) {
 		loginname := r.PostFormValue("name")
 		password := r.PostFormValue("password")
 
-		acc = Accounts.AccountForLogin(loginname, password)
+		acc, err = Accounts.AccountForLogin(loginname, password)
+		if err != nil && StoreFailureOf(err) != StoreNotFound {
+			StoreErrorResponse(w, err)
+			return
+		}
 		if acc != nil {
 			SetAccountForRequest(w, r, acc)
 
@@ -129,17 +164,22 @@ func WebSignIn(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	RenderTemplate(w, r, "signin.html", map[string]interface{}{
-		"CsrfToken": nosurf.Token(r),
-		"Title":     "Sign in",
+	// Only lock the world to render, as checking the password is slow.
+	WithWorld(func() {
+		RenderTemplate(w, r, "signin.html", map[string]interface{}{
+			"CsrfToken": nosurf.Token(r),
+			"Title":     "Sign in",
+		})
 	})
 }
 
 func WebSignOut(w http.ResponseWriter, r *http.Request) {
 	// Don't really care if there's an account already or no.
 	SetAccountForRequest(w, r, nil)
-	RenderTemplate(w, r, "signout.html", map[string]interface{}{
-		"Title": "Sign out",
+	WithWorld(func() {
+		RenderTemplate(w, r, "signout.html", map[string]interface{}{
+			"Title": "Sign out",
+		})
 	})
 }
 
@@ -185,6 +225,15 @@ func deleteMapFrom(source map[string]interface{}, target map[string]interface{})
 	return target
 }
 
+// setOrInherit sets a table value from a web form, unless the value is blank and the thing can inherit one from its prototype instead.
+func setOrInherit(thing *Thing, key string, value string) {
+	if value == "" && thing.Inherits(key) != nil {
+		delete(thing.Table, key)
+		return
+	}
+	thing.Table[key] = value
+}
+
 func WebThingTable(w http.ResponseWriter, r *http.Request) {
 	thing := context.Get(r, ContextKeyThing).(*Thing)
 	account := context.Get(r, ContextKeyAccount).(*Account)
@@ -195,9 +244,9 @@ func WebThingTable(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == "POST" {
+		// Updates can refer to things as {"$thing": id}.
 		updateText := r.PostFormValue("updated_data")
-		var updates map[string]interface{}
-		err := json.Unmarshal([]byte(updateText), &updates)
+		updates, err := DecodeTable([]byte(updateText))
 		if err != nil {
 			// aw carp
 			// TODO: set a flash?
@@ -215,19 +264,57 @@ func WebThingTable(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		for _, changed := range []map[string]interface{}{updates, deletes} {
+			for key := range changed {
+				if !MayChangeTableKey(account.Character, key) {
+					http.Error(w, fmt.Sprintf("Only superusers can change %s", key), http.StatusForbidden)
+					return
+				}
+			}
+		}
+
 		thing.Table = mergeMapInto(updates, thing.Table)
 		thing.Table = deleteMapFrom(deletes, thing.Table)
-		World.SaveThing(thing)
+		err = SaveThingBy(account.Character, thing)
+		if err != nil {
+			StoreErrorResponse(w, err)
+			return
+		}
 
 		http.Redirect(w, r, fmt.Sprintf("%stable", thing.GetURL()), http.StatusSeeOther)
 		return
 	}
 
+	// Find the values the thing inherits from its prototypes, so the editor can show them too.
+	inherited := make(map[string]interface{})
+	refIds := TableThingRefs(thing.Table)
+	for key, proto := range thing.Inherited() {
+		inherited[key] = map[string]interface{}{
+			"value": proto.Table[key],
+			"from":  int64(proto.Id),
+		}
+		refIds = append(refIds, proto.Id)
+		refIds = append(refIds, TableThingRefs(proto.Table[key])...)
+	}
+
+	// Describe the things the table refers to, so the editor can show them as thinglinks.
+	references := make(map[string]interface{})
+	for _, refId := range refIds {
+		if ref := GetThing(refId); ref != nil {
+			references[strconv.FormatInt(int64(refId), 10)] = map[string]interface{}{
+				"name": ref.Name,
+				"type": ref.Type.String(),
+			}
+		}
+	}
+
 	RenderTemplate(w, r, "thing/page/table.html", map[string]interface{}{
-		"Title": fmt.Sprintf("Edit all data – %s", thing.Name),
-		"Thing": thing,
+		"Title":      fmt.Sprintf("Edit all data – %s", thing.Name),
+		"Thing":      thing,
+		"Inherited":  inherited,
+		"References": references,
 		"json": func(v interface{}) interface{} {
-			output, err := json.MarshalIndent(v, "", "    ")
+			output, err := json.MarshalIndent(encodeThingRefs(v), "", "    ")
 			if err != nil {
 				escapedError := template.JSEscapeString(err.Error())
 				message := fmt.Sprintf("/* error encoding JSON: %s */ {}", escapedError)
@@ -253,22 +340,60 @@ func WebThingProgram(w http.ResponseWriter, r *http.Request) {
 
 		newProgram = NewProgram(program)
 		if newProgram.Error == nil {
-			thing.Program = newProgram
-			World.SaveThing(thing)
+			thing.ChangeProgramBy(account.Character, newProgram)
+			err := SaveThingBy(account.Character, thing)
+			if err != nil {
+				StoreErrorResponse(w, err)
+				return
+			}
 
 			http.Redirect(w, r, fmt.Sprintf("%sprogram", thing.GetURL()), http.StatusSeeOther)
 			return
 		}
 	}
 
+	scriptErrs, err := World.ScriptErrors(thing.Id)
+	if err != nil {
+		StoreErrorResponse(w, err)
+		return
+	}
+
+	// Mark the lines with errors in the editor: the new program's error if it wouldn't compile, or else the errors logged for the saved program, newest first.
+	var marks []programErrorMark
+	if newProgram != nil {
+		if scriptErr, ok := newProgram.Error.(*ScriptError); ok && scriptErr.Line != 0 {
+			marks = append(marks, programErrorMark{scriptErr.Line, scriptErr.Error()})
+		}
+	} else {
+		marked := make(map[int]bool)
+		for _, scriptErr := range scriptErrs {
+			if scriptErr.Line != 0 && !marked[scriptErr.Line] {
+				marked[scriptErr.Line] = true
+				marks = append(marks, programErrorMark{scriptErr.Line, fmt.Sprintf("%s: %s", scriptErr.Where(), scriptErr.Message)})
+			}
+		}
+	}
+	marksJson, err := json.Marshal(marks)
+	if err != nil {
+		marksJson = []byte("[]")
+	}
+
 	RenderTemplate(w, r, "thing/page/program.html", map[string]interface{}{
 		"IncludeCodeMirror": true,
 		"Title":             fmt.Sprintf("Edit program – %s", thing.Name),
 		"Thing":             thing,
 		"Program":           newProgram,
+		"Errors":            scriptErrs,
+		"ErrorMarks":        template.JS(marksJson),
 	})
 }
 
+// programErrorMark is an error shown in the program editor at the line it happened.
+type programErrorMark struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
 func WebThingAccess(w http.ResponseWriter, r *http.Request) {
 	account := context.Get(r, ContextKeyAccount).(*Account)
 	thing := context.Get(r, ContextKeyThing).(*Thing)
@@ -295,7 +420,18 @@ func WebThingAccess(w http.ResponseWriter, r *http.Request) {
 			changed = true
 		}
 
-		// TODO: handle allows
+		if allowedText := r.PostFormValue("allowed"); allowedText != "" {
+			var allowedIds []ThingId
+			err := json.Unmarshal([]byte(allowedText), &allowedIds)
+			if err != nil {
+				// TODO: set a flash
+				http.Redirect(w, r, fmt.Sprintf("%saccess", thing.GetURL()), http.StatusSeeOther)
+				return
+			}
+
+			thing.AllowList = allowedIds
+			changed = true
+		}
 
 		if deniedText := r.PostFormValue("denied"); deniedText != "" {
 			var deniedIds []ThingId
@@ -311,7 +447,11 @@ func WebThingAccess(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if changed {
-			World.SaveThing(thing)
+			err := SaveThingBy(account.Character, thing)
+			if err != nil {
+				StoreErrorResponse(w, err)
+				return
+			}
 		}
 
 		http.Redirect(w, r, fmt.Sprintf("%saccess", thing.GetURL()), http.StatusSeeOther)
@@ -324,6 +464,112 @@ func WebThingAccess(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// historyEntry is a revision on a thing's history page, with how it changed the thing.
+type historyEntry struct {
+	Revision *ThingRevision
+	Changes  []RevisionChange
+	Current  bool
+}
+
+func WebThingHistory(w http.ResponseWriter, r *http.Request) {
+	thing := context.Get(r, ContextKeyThing).(*Thing)
+	account := context.Get(r, ContextKeyAccount).(*Account)
+
+	if !thing.EditableById(account.Character) {
+		http.Error(w, "No access to history", http.StatusForbidden)
+		return
+	}
+
+	revs, err := World.ThingRevisions(thing.Id)
+	if err != nil {
+		StoreErrorResponse(w, err)
+		return
+	}
+
+	if r.Method == "POST" {
+		var rev *ThingRevision
+		revId, err := strconv.ParseInt(r.PostFormValue("revision"), 10, 64)
+		if err == nil {
+			for _, candRev := range revs {
+				if candRev.Id == revId {
+					rev = candRev
+					break
+				}
+			}
+		}
+		if rev == nil {
+			http.Error(w, "No such revision to revert to", http.StatusBadRequest)
+			return
+		}
+
+		err = rev.Revert(thing, account.Character)
+		if err != nil {
+			StoreErrorResponse(w, err)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("%shistory", thing.GetURL()), http.StatusSeeOther)
+		return
+	}
+
+	history := make([]historyEntry, len(revs))
+	for i, rev := range revs {
+		var older *ThingRevision
+		if i+1 < len(revs) {
+			older = revs[i+1]
+		}
+		history[i] = historyEntry{rev, rev.ChangesSince(older), i == 0}
+	}
+
+	RenderTemplate(w, r, "thing/page/history.html", map[string]interface{}{
+		"Title":   fmt.Sprintf("History – %s", thing.Name),
+		"Thing":   thing,
+		"History": history,
+	})
+}
+
+func WebThingPackage(w http.ResponseWriter, r *http.Request) {
+	thing := context.Get(r, ContextKeyThing).(*Thing)
+	account := context.Get(r, ContextKeyAccount).(*Account)
+
+	pkg, err := PackageArea(thing, account.Character)
+	if err != nil {
+		StoreErrorResponse(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"area-%d.json\"", thing.Id))
+	_, err = pkg.WriteTo(w)
+	if err != nil {
+		log.Println("Error writing package of area", thing.Id, ":", err.Error())
+	}
+}
+
+func WebThingRecycle(w http.ResponseWriter, r *http.Request) {
+	thing := context.Get(r, ContextKeyThing).(*Thing)
+	account := context.Get(r, ContextKeyAccount).(*Account)
+
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "POST only", http.StatusBadRequest)
+		return
+	}
+
+	if !thing.DestroyableById(account.Character) {
+		http.Error(w, "No access to recycle", http.StatusForbidden)
+		return
+	}
+
+	err := RecycleThing(thing)
+	if err != nil {
+		StoreErrorResponse(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
 func WebThingEdit(w http.ResponseWriter, r *http.Request) {
 	thing := context.Get(r, ContextKeyThing).(*Thing)
 	account := context.Get(r, ContextKeyAccount).(*Account)
@@ -337,34 +583,44 @@ func WebThingEdit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == "POST" {
+		// Move the thing first, so if the viewer can't move it there, nothing else changes either.
+		parentIdStr := r.PostFormValue("parent")
+		parentId64, err := strconv.ParseInt(parentIdStr, 10, 64)
+		if err != nil {
+			// TODO: set a flash? cause an error? eh
+		} else if parentId := ThingId(parentId64); parentId != thing.Parent {
+			newParent := GetThing(parentId)
+			err = thing.MoveToBy(account.Character, newParent)
+			if moveErr, ok := err.(*MoveError); ok {
+				status := http.StatusBadRequest
+				if moveErr.Reason == MoveNotControlled || moveErr.Reason == MoveTargetNotControlled {
+					status = http.StatusForbidden
+				}
+				http.Error(w, moveErr.Error(), status)
+				return
+			} else if err != nil {
+				StoreErrorResponse(w, err)
+				return
+			}
+		}
+
 		// TODO: player names should be unique?
 		// TODO: account loginnames should match their player names?
 		thing.Name = r.PostFormValue("name")
 
 		// TODO: validate??
-		thing.Table["description"] = r.PostFormValue("description")
+		setOrInherit(thing, "description", r.PostFormValue("description"))
 		if thing.Type == PlayerThing {
-			thing.Table["glance"] = r.PostFormValue("glance")
+			setOrInherit(thing, "glance", r.PostFormValue("glance"))
 			thing.Table["pronouns"] = r.PostFormValue("pronouns")
 		}
 
-		parentIdStr := r.PostFormValue("parent")
-		parentId64, err := strconv.ParseInt(parentIdStr, 10, 64)
+		err = SaveThingBy(account.Character, thing)
 		if err != nil {
-			// TODO: set a flash? cause an error? eh
-		} else {
-			parentId := ThingId(parentId64)
-			newParent := World.ThingForId(parentId)
-			// TODO: does the viewer control newParent sufficiently to move the thing there?
-			if newParent == nil {
-				// TODO: set a flash? cause an error? eh
-			} else {
-				thing.Parent = parentId
-			}
+			StoreErrorResponse(w, err)
+			return
 		}
 
-		World.SaveThing(thing)
-
 		http.Redirect(w, r, thing.GetURL(), http.StatusSeeOther)
 		return
 	}
@@ -387,11 +643,14 @@ func WebThing(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	thing := World.ThingForId(ThingId(thingId))
-	if thing == nil {
+	thing, err := World.ThingForId(ThingId(thingId))
+	if StoreFailureOf(err) == StoreNotFound {
 		// regular ol' expected not-found this time
 		http.NotFound(w, r)
 		return
+	} else if err != nil {
+		StoreErrorResponse(w, err)
+		return
 	}
 
 	// Make sure we're at the right URL.
@@ -427,24 +686,152 @@ func WebCreateThing(w http.ResponseWriter, r *http.Request) {
 	}
 
 	account := context.Get(r, ContextKeyAccount).(*Account)
-	accPlayer := World.ThingForId(account.Character)
+	accPlayer, err := World.ThingForId(account.Character)
+	if err != nil {
+		StoreErrorResponse(w, err)
+		return
+	}
 
 	parent := accPlayer
 	if thingType == PlaceThing {
-		parent = World.ThingForId(1)
+		parent, err = World.ThingForId(1)
+		if err != nil {
+			StoreErrorResponse(w, err)
+			return
+		}
 	}
 
 	name := fmt.Sprintf("New %s", strings.Title(thingType.String()))
-	thing := World.CreateThing(name, thingType, accPlayer, parent)
+	thing, err := World.CreateThing(name, thingType, accPlayer, parent)
+	if err != nil {
+		StoreErrorResponse(w, err)
+		return
+	}
+	NotifyCreated(thing)
 
 	http.Redirect(w, r, thing.GetURL(), http.StatusSeeOther)
 }
 
+func WebInstallArea(w http.ResponseWriter, r *http.Request) {
+	account := context.Get(r, ContextKeyAccount).(*Account)
+
+	if r.Method != "POST" {
+		RenderTemplate(w, r, "install-area.html", map[string]interface{}{
+			"Title": "Install an area",
+		})
+		return
+	}
+
+	file, _, err := r.FormFile("package")
+	if err != nil {
+		http.Error(w, "No area package to install", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	pkg, err := ReadAreaPackage(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	accPlayer, err := World.ThingForId(account.Character)
+	if err != nil {
+		StoreErrorResponse(w, err)
+		return
+	}
+	area, dangling, err := InstallArea(pkg, accPlayer)
+	if err != nil {
+		log.Println("Error installing area package:", err.Error())
+		StoreErrorResponse(w, err)
+		return
+	}
+
+	RenderTemplate(w, r, "install-area.html", map[string]interface{}{
+		"Title":    "Installed an area",
+		"Area":     area,
+		"Dangling": dangling,
+	})
+}
+
+func WebSearch(w http.ResponseWriter, r *http.Request) {
+	account := context.Get(r, ContextKeyAccount).(*Account)
+	accPlayer, err := World.ThingForId(account.Character)
+	if err != nil {
+		StoreErrorResponse(w, err)
+		return
+	}
+
+	form := r.URL.Query()
+	query := &ThingQuery{
+		Type:     ThingTypeForName(form.Get("type")),
+		TableKey: form.Get("key"),
+	}
+	if form.Get("match") == "prefix" {
+		query.NamePrefix = form.Get("name")
+	} else {
+		query.NameContains = form.Get("name")
+	}
+	for field, id := range map[string]*ThingId{"owner": &query.Owner, "creator": &query.Creator, "in": &query.Parent} {
+		if text := form.Get(field); text != "" {
+			var ok bool
+			*id, ok = ParseFindThing(accPlayer, text)
+			if !ok {
+				http.Error(w, fmt.Sprintf("Not sure what thing you meant by \"%s\"", text), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if value := form.Get("value"); value != "" {
+		query.TableValue = ParseTableValue(value)
+	}
+	if limit := form.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			http.Error(w, "The limit should be a number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var results []*Thing
+	var more bool
+	searched := *query != (ThingQuery{})
+	if searched {
+		results, more, err = FindThingsBy(query, func(thing *Thing) bool {
+			return thing.EditableById(account.Character)
+		})
+		if err != nil {
+			StoreErrorResponse(w, err)
+			return
+		}
+	}
+
+	RenderTemplate(w, r, "search.html", map[string]interface{}{
+		"Title":    "Search",
+		"Form":     form,
+		"Searched": searched,
+		"Results":  results,
+		"More":     more,
+	})
+}
+
 func WebIndex(w http.ResponseWriter, r *http.Request) {
 	account := context.Get(r, ContextKeyAccount).(*Account)
+	places, err := FindThingsFor(&ThingQuery{Owner: account.Character, Type: PlaceThing})
+	if err != nil {
+		StoreErrorResponse(w, err)
+		return
+	}
+	programs, err := FindThingsFor(&ThingQuery{Owner: account.Character, Type: ProgramThing})
+	if err != nil {
+		StoreErrorResponse(w, err)
+		return
+	}
+
 	RenderTemplate(w, r, "index.html", map[string]interface{}{
-		"Title":  "Home",
-		"Player": World.ThingForId(account.Character),
+		"Title":    "Home",
+		"Player":   GetThing(account.Character),
+		"Places":   places,
+		"Programs": programs,
 	})
 }
 
@@ -513,8 +900,13 @@ func StartWeb() {
 	webThingMux.HandleFunc("/table", WebThingTable)
 	webThingMux.HandleFunc("/program", WebThingProgram)
 	webThingMux.HandleFunc("/access", WebThingAccess)
+	webThingMux.HandleFunc("/history", WebThingHistory)
+	webThingMux.HandleFunc("/package", WebThingPackage)
+	webThingMux.HandleFunc("/recycle", WebThingRecycle)
 
 	http.Handle("/create-thing", RequireAccountFunc(WebCreateThing))
+	http.Handle("/install-area", RequireAccountFunc(WebInstallArea))
+	http.Handle("/search", RequireAccountFunc(WebSearch))
 
 	indexHandler := RequireAccountFunc(WebIndex)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
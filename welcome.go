@@ -1,6 +1,7 @@
 package mess
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
@@ -79,9 +80,13 @@ func WelcomeConnect(client *ClientPump, rest string) (endWelcome bool) {
 
 	// TODO: eventually connections should be made through a front-end that talks to a service, so the service can be restarted independently of the front-end. This would be very different.
 
-	account := Accounts.AccountForLogin(name, password)
-	if account == nil {
-		client.Send("Hmm, there doesn't appear to be an account with that name and password.")
+	account, err := Accounts.AccountForLogin(name, password)
+	if err != nil {
+		if StoreFailureOf(err) == StoreNotFound {
+			client.Send("Hmm, there doesn't appear to be an account with that name and password.")
+		} else {
+			client.Send(fmt.Sprintf("Oops, we were unable to connect you. %s", StoreErrorMessage(err)))
+		}
 		return false
 	}
 
@@ -101,9 +106,21 @@ func WelcomeRegister(client *ClientPump, rest string) {
 	}
 	name, password := parts[0], parts[1]
 
-	account := Accounts.CreateAccount(name, password)
-	if account == nil {
-		client.Send("Oops, we were unable to register you with that name.")
+	// Hash the password before locking the world, as it's slow.
+	passwordHash, err := HashPassword(password)
+	if err == nil {
+		WithWorld(func() {
+			var account *Account
+			account, err = Accounts.CreateAccount(name, passwordHash)
+			if err == nil {
+				if char := GetThing(account.Character); char != nil {
+					NotifyCreated(char)
+				}
+			}
+		})
+	}
+	if err != nil {
+		client.Send(fmt.Sprintf("Oops, we were unable to register you with that name. %s", StoreErrorMessage(err)))
 		return
 	}
 
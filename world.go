@@ -1,17 +1,20 @@
 package mess
 
 import (
+	"container/list"
 	"database/sql"
 	"database/sql/driver"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/bmizerany/pq"
 	"github.com/jmoiron/sqlx/types"
 	"log"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var thingIdListExp = regexp.MustCompile(`\d+`)
@@ -63,26 +66,112 @@ func (tt ThingType) Value() (driver.Value, error) {
 	return []byte(tt), nil
 }
 
+// StoreFailure is the kind of problem a WorldStore or AccountStore had.
+type StoreFailure int
+
+const (
+	StoreUnavailable StoreFailure = iota
+	StoreNotFound
+	StoreDuplicateLogin
+	StoreConstraintViolation
+)
+
+// StoreError is the error from a WorldStore or AccountStore operation that failed.
+type StoreError struct {
+	Failure StoreFailure
+	Err     error
+}
+
+func (err *StoreError) Error() string {
+	switch err.Failure {
+	case StoreNotFound:
+		return fmt.Sprintf("not found: %s", err.Err.Error())
+	case StoreDuplicateLogin:
+		return fmt.Sprintf("login name already taken: %s", err.Err.Error())
+	case StoreConstraintViolation:
+		return fmt.Sprintf("constraint violation: %s", err.Err.Error())
+	}
+	return fmt.Sprintf("storage unavailable: %s", err.Err.Error())
+}
+
+// StoreFailureOf finds what kind of failure err is. Errors that didn't come from a store count as the store being unavailable.
+func StoreFailureOf(err error) StoreFailure {
+	if storeErr, ok := err.(*StoreError); ok {
+		return storeErr.Failure
+	}
+	return StoreUnavailable
+}
+
+func notFoundError(format string, args ...interface{}) error {
+	return &StoreError{StoreNotFound, fmt.Errorf(format, args...)}
+}
+
+// databaseError classifies an error from PostgreSQL as a StoreError.
+func databaseError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*StoreError); ok {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return &StoreError{StoreNotFound, err}
+	}
+	if pgErr, ok := err.(pq.PGError); ok {
+		// Class 23 is integrity constraint violations.
+		if strings.HasPrefix(pgErr.Get('C'), "23") {
+			return &StoreError{StoreConstraintViolation, errors.New(pgErr.Get('M'))}
+		}
+	}
+	return &StoreError{StoreUnavailable, err}
+}
+
+// isUniqueViolation reports whether err is PostgreSQL refusing a duplicate key.
+func isUniqueViolation(err error) bool {
+	pgErr, ok := err.(pq.PGError)
+	return ok && pgErr.Get('C') == "23505"
+}
+
 type WorldStore interface {
-	ThingForId(id ThingId) *Thing
-	CreateThing(name string, tt ThingType, creator *Thing, parent *Thing) (thing *Thing)
-	MoveThing(thing *Thing, target *Thing) (ok bool)
-	SaveThing(thing *Thing) (ok bool)
+	ThingForId(id ThingId) (*Thing, error)
+	CreateThing(name string, tt ThingType, creator *Thing, parent *Thing) (*Thing, error)
+	MoveThing(thing *Thing, target *Thing) error
+	SaveThing(thing *Thing) error
+	DestroyThing(thing *Thing) error
+	SaveRevisions(revs []*ThingRevision) error
+	ThingRevisions(id ThingId) ([]*ThingRevision, error)
+	FindThings(q *ThingQuery) ([]ThingId, error)
+	SaveTimer(timer *Timer) error
+	DeleteTimer(id int64) error
+	AllTimers() ([]*Timer, error)
+	SaveScriptError(scriptErr *ScriptError) error
+	ScriptErrors(id ThingId) ([]*ScriptError, error)
+	ClearScriptErrors(id ThingId) error
+}
+
+// BatchSaver is a WorldStore that can save many things at once, in one transaction.
+type BatchSaver interface {
+	SaveThings(things []*Thing) error
+}
+
+// execer is what sql.DB & sql.Tx have in common for running statements.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
 }
 
 type DatabaseWorld struct {
 	db *sql.DB
 }
 
-func (w *DatabaseWorld) ThingForId(id ThingId) (thing *Thing) {
+func (w *DatabaseWorld) ThingForId(id ThingId) (*Thing, error) {
 	if id == 0 {
-		return nil
+		return nil, notFoundError("there is no thing #0")
 	}
 
-	thing = NewThing()
+	thing := NewThing()
 	thing.Id = id
 
-	row := w.db.QueryRow("SELECT type, name, creator, created, owner, superuser, adminlist, allowlist, denylist, parent, tabledata, program FROM thing WHERE id = $1",
+	row := w.db.QueryRow("SELECT type, name, creator, created, owner, superuser, wizard, adminlist, allowlist, denylist, parent, tabledata, program FROM thing WHERE id = $1",
 		id)
 	var creator sql.NullInt64
 	var owner sql.NullInt64
@@ -90,11 +179,11 @@ func (w *DatabaseWorld) ThingForId(id ThingId) (thing *Thing) {
 	var tabledata types.JsonText
 	var program sql.NullString
 	err := row.Scan(&thing.Type, &thing.Name, &creator, &thing.Created, &owner,
-		&thing.Superuser, &thing.AdminList, &thing.AllowList, &thing.DenyList,
+		&thing.Superuser, &thing.Wizard, &thing.AdminList, &thing.AllowList, &thing.DenyList,
 		&parent, &tabledata, &program)
 	if err != nil {
 		log.Println("Error finding thing", id, ":", err.Error())
-		return nil
+		return nil, databaseError(err)
 	}
 	if creator.Valid {
 		thing.Creator = ThingId(creator.Int64)
@@ -106,12 +195,12 @@ func (w *DatabaseWorld) ThingForId(id ThingId) (thing *Thing) {
 		thing.Parent = ThingId(parent.Int64)
 	}
 	if program.Valid {
-		thing.Program = NewProgram(program.String)
+		thing.Program = LoadProgram(program.String)
 	}
-	err = tabledata.Unmarshal(&thing.Table)
+	thing.Table, err = DecodeTable([]byte(tabledata))
 	if err != nil {
 		log.Println("Error finding table data for thing", id, ":", err.Error())
-		return nil
+		return nil, &StoreError{StoreConstraintViolation, err}
 	}
 
 	// Find thing's contents.
@@ -119,29 +208,29 @@ func (w *DatabaseWorld) ThingForId(id ThingId) (thing *Thing) {
 		contentRows, err := w.db.Query("SELECT id FROM thing WHERE parent = $1", id)
 		if err != nil {
 			log.Println("Error finding contents", id, ":", err.Error())
-			return nil
+			return nil, databaseError(err)
 		}
 		defer contentRows.Close()
 		for contentRows.Next() {
 			var childId ThingId
 			if err := contentRows.Scan(&childId); err != nil {
 				log.Println("Error finding contents", id, ":", err.Error())
-				return nil
+				return nil, databaseError(err)
 			}
 
 			thing.Contents = append(thing.Contents, childId)
 		}
 		if err := contentRows.Err(); err != nil {
 			log.Println("Error finding contents", id, ":", err.Error())
-			return nil
+			return nil, databaseError(err)
 		}
 	}
 
-	return
+	return thing, nil
 }
 
-func (w *DatabaseWorld) CreateThing(name string, tt ThingType, creator *Thing, parent *Thing) (thing *Thing) {
-	thing = NewThing()
+func (w *DatabaseWorld) CreateThing(name string, tt ThingType, creator *Thing, parent *Thing) (*Thing, error) {
+	thing := NewThing()
 	thing.Name = name
 	thing.Type = tt
 	thing.Parent = parent.Id
@@ -149,6 +238,8 @@ func (w *DatabaseWorld) CreateThing(name string, tt ThingType, creator *Thing, p
 	var creatorId sql.NullInt64
 	if creator != nil && thing.Type.HasOwner() {
 		creatorId.Int64 = int64(creator.Id)
+		// Mark the creator valid so it isn't saved as NULL.
+		creatorId.Valid = true
 		thing.Creator = creator.Id
 		thing.Owner = creator.Id
 	}
@@ -158,27 +249,63 @@ func (w *DatabaseWorld) CreateThing(name string, tt ThingType, creator *Thing, p
 	err := row.Scan(&thing.Id, &thing.Created)
 	if err != nil {
 		log.Println("Error creating a thing", name, ":", err.Error())
-		return nil
+		return nil, databaseError(err)
 	}
 
-	return
+	return thing, nil
 }
 
-func (w *DatabaseWorld) MoveThing(thing *Thing, target *Thing) (ok bool) {
+func (w *DatabaseWorld) MoveThing(thing *Thing, target *Thing) error {
 	_, err := w.db.Exec("UPDATE thing SET parent = $1 WHERE id = $2",
 		target.Id, thing.Id)
 	if err != nil {
 		log.Println("Error moving a thing", thing.Id, ":", err.Error())
-		return false
+		return databaseError(err)
+	}
+	return nil
+}
+
+func (w *DatabaseWorld) SaveThing(thing *Thing) error {
+	return saveDatabaseThing(w.db, thing)
+}
+
+// SaveThings saves all the things in one transaction.
+func (w *DatabaseWorld) SaveThings(things []*Thing) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		log.Println("Couldn't open transaction to save things:", err.Error())
+		return databaseError(err)
+	}
+	for _, thing := range things {
+		err = saveDatabaseThing(tx, thing)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println("Couldn't commit transaction to save things:", err.Error())
+		return databaseError(err)
 	}
-	return true
+	return nil
+}
+
+// SetSuperuser makes the thing a superuser or not. Saving a thing never changes that, so this is only for importing worlds.
+func (w *DatabaseWorld) SetSuperuser(id ThingId, superuser bool) error {
+	_, err := w.db.Exec("UPDATE thing SET superuser = $1 WHERE id = $2", superuser, id)
+	if err != nil {
+		log.Println("Error setting whether thing", id, "is a superuser:", err.Error())
+		return databaseError(err)
+	}
+	return nil
 }
 
-func (w *DatabaseWorld) SaveThing(thing *Thing) (ok bool) {
-	tabletext, err := json.Marshal(thing.Table)
+func saveDatabaseThing(db execer, thing *Thing) error {
+	tabletext, err := EncodeTable(thing.Table)
 	if err != nil {
 		log.Println("Error serializing table data for thing", thing.Id, ":", err.Error())
-		return false
+		return &StoreError{StoreConstraintViolation, err}
 	}
 
 	var parent sql.NullInt64
@@ -197,84 +324,1107 @@ func (w *DatabaseWorld) SaveThing(thing *Thing) (ok bool) {
 		program.Valid = true
 	}
 
-	// TODO: save the allow list
-	_, err = w.db.Exec("UPDATE thing SET name = $1, parent = $2, owner = $3, adminlist = $4, denylist = $5, tabledata = $6, program = $7 WHERE id = $8",
-		thing.Name, parent, owner, thing.AdminList, thing.DenyList,
-		types.JsonText(tabletext), program, thing.Id)
+	_, err = db.Exec("UPDATE thing SET name = $1, parent = $2, owner = $3, adminlist = $4, allowlist = $5, denylist = $6, tabledata = $7, program = $8, wizard = $9 WHERE id = $10",
+		thing.Name, parent, owner, thing.AdminList, thing.AllowList, thing.DenyList,
+		types.JsonText(tabletext), program, thing.Wizard, thing.Id)
 	if err != nil {
 		log.Println("Error saving a thing", thing.Id, ":", err.Error())
-		return false
+		return databaseError(err)
 	}
-	return true
+	return nil
+}
+
+func (w *DatabaseWorld) DestroyThing(thing *Thing) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		log.Println("Couldn't open transaction to destroy thing", thing.Id, ":", err.Error())
+		return databaseError(err)
+	}
+
+	var numAccounts int
+	row := tx.QueryRow("SELECT COUNT(*) FROM account WHERE character = $1", thing.Id)
+	err = row.Scan(&numAccounts)
+	if err != nil {
+		log.Println("Error destroying thing", thing.Id, ":", err.Error())
+		tx.Rollback()
+		return databaseError(err)
+	}
+	if numAccounts > 0 {
+		log.Println("Refusing to destroy thing", thing.Id, "as it's the character of an account")
+		tx.Rollback()
+		return &StoreError{StoreConstraintViolation, fmt.Errorf("%s is the character of an account", thing.Name)}
+	}
+
+	// Actions go with the thing, but its other contents are rescued.
+	_, err = tx.Exec("UPDATE thing SET parent = $1 WHERE parent = $2 AND type <> 'action'",
+		thing.RecycleHome(), thing.Id)
+	if err != nil {
+		log.Println("Error moving contents of thing", thing.Id, "to destroy it:", err.Error())
+		tx.Rollback()
+		return databaseError(err)
+	}
+
+	actions, err := childActionsInTx(tx, "SELECT id FROM thing WHERE parent = $1 AND type = 'action'", thing.Id)
+	if err == nil {
+		err = removeTableRefsInTx(tx, `SELECT id, tabledata FROM thing WHERE tabledata::text LIKE '%"$thing"%' OR tabledata::text LIKE '%"target"%'`,
+			"UPDATE thing SET tabledata = $1 WHERE id = $2", append(ThingIdList{thing.Id}, actions...))
+	}
+	if err != nil {
+		log.Println("Error removing references to thing", thing.Id, "to destroy it:", err.Error())
+		tx.Rollback()
+		return databaseError(err)
+	}
+
+	statements := []string{
+		"DELETE FROM thing_revision WHERE thing IN (SELECT id FROM thing WHERE parent = $1 AND type = 'action')",
+		"DELETE FROM timer WHERE thing IN (SELECT id FROM thing WHERE parent = $1 AND type = 'action')",
+		"DELETE FROM script_error WHERE thing IN (SELECT id FROM thing WHERE parent = $1 AND type = 'action')",
+		"DELETE FROM thing WHERE parent = $1 AND type = 'action'",
+		"UPDATE thing SET adminlist = array_remove(adminlist, $1), allowlist = array_remove(allowlist, $1), denylist = array_remove(denylist, $1) WHERE $1 = ANY(adminlist) OR $1 = ANY(allowlist) OR $1 = ANY(denylist)",
+		"UPDATE thing SET creator = NULL WHERE creator = $1",
+		"UPDATE thing SET owner = NULL WHERE owner = $1",
+		"UPDATE thing_revision SET editor = NULL WHERE editor = $1",
+		"UPDATE script_error SET player = NULL WHERE player = $1",
+		"DELETE FROM thing_revision WHERE thing = $1",
+		"DELETE FROM timer WHERE thing = $1",
+		"DELETE FROM script_error WHERE thing = $1",
+		"DELETE FROM thing WHERE id = $1",
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(statement, thing.Id)
+		if err != nil {
+			log.Println("Error destroying thing", thing.Id, ":", err.Error())
+			tx.Rollback()
+			return databaseError(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("Couldn't commit transaction to destroy thing", thing.Id, ":", err.Error())
+		tx.Rollback()
+		return databaseError(err)
+	}
+	return nil
+}
+
+// SaveRevisions adds the revisions to their things' histories, all in one transaction.
+func (w *DatabaseWorld) SaveRevisions(revs []*ThingRevision) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		log.Println("Couldn't open transaction to save revisions:", err.Error())
+		return databaseError(err)
+	}
+	for _, rev := range revs {
+		tabletext, err := EncodeTable(rev.Table)
+		if err != nil {
+			log.Println("Error serializing table data for revision of thing", rev.Thing, ":", err.Error())
+			tx.Rollback()
+			return &StoreError{StoreConstraintViolation, err}
+		}
+
+		var editor, owner sql.NullInt64
+		if rev.Editor != 0 {
+			editor.Int64 = int64(rev.Editor)
+			editor.Valid = true
+		}
+		if rev.Owner != 0 {
+			owner.Int64 = int64(rev.Owner)
+			owner.Valid = true
+		}
+		var program sql.NullString
+		if rev.Program != "" {
+			program.String = rev.Program
+			program.Valid = true
+		}
+
+		row := tx.QueryRow("INSERT INTO thing_revision (thing, editor, created, name, owner, adminlist, allowlist, denylist, tabledata, program) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id",
+			rev.Thing, editor, rev.Created, rev.Name, owner, rev.AdminList, rev.AllowList, rev.DenyList,
+			types.JsonText(tabletext), program)
+		err = row.Scan(&rev.Id)
+		if err != nil {
+			log.Println("Error saving revision of thing", rev.Thing, ":", err.Error())
+			tx.Rollback()
+			return databaseError(err)
+		}
+	}
+	err = pruneRevisionsInTx(tx, "DELETE FROM thing_revision WHERE thing = $1 AND id <= (SELECT id FROM thing_revision WHERE thing = $1 ORDER BY id DESC LIMIT 1 OFFSET $2)", revs)
+	if err != nil {
+		log.Println("Error forgetting old revisions:", err.Error())
+		tx.Rollback()
+		return databaseError(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println("Couldn't commit transaction to save revisions:", err.Error())
+		return databaseError(err)
+	}
+	return nil
+}
+
+// ThingRevisions finds the history of the thing with the given id, newest first.
+func (w *DatabaseWorld) ThingRevisions(id ThingId) ([]*ThingRevision, error) {
+	rows, err := w.db.Query("SELECT id, editor, created, name, owner, adminlist, allowlist, denylist, tabledata, program FROM thing_revision WHERE thing = $1 ORDER BY id DESC",
+		id)
+	if err != nil {
+		log.Println("Error finding revisions of thing", id, ":", err.Error())
+		return nil, databaseError(err)
+	}
+	defer rows.Close()
+
+	var revs []*ThingRevision
+	for rows.Next() {
+		rev := &ThingRevision{Thing: id}
+		var editor, owner sql.NullInt64
+		var tabledata types.JsonText
+		var program sql.NullString
+		err = rows.Scan(&rev.Id, &editor, &rev.Created, &rev.Name, &owner,
+			&rev.AdminList, &rev.AllowList, &rev.DenyList, &tabledata, &program)
+		if err != nil {
+			log.Println("Error finding revisions of thing", id, ":", err.Error())
+			return nil, databaseError(err)
+		}
+		rev.Editor = ThingId(editor.Int64)
+		rev.Owner = ThingId(owner.Int64)
+		rev.Program = program.String
+		rev.Table, err = DecodeTable([]byte(tabledata))
+		if err != nil {
+			log.Println("Error finding table data for revision", rev.Id, ":", err.Error())
+			return nil, &StoreError{StoreConstraintViolation, err}
+		}
+		revs = append(revs, rev)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Println("Error finding revisions of thing", id, ":", err.Error())
+		return nil, databaseError(err)
+	}
+	return revs, nil
+}
+
+// SaveTimer adds the timer to the saved timers, or updates it if it's already saved.
+func (w *DatabaseWorld) SaveTimer(timer *Timer) error {
+	var owner sql.NullInt64
+	if timer.Owner != 0 {
+		owner.Int64 = int64(timer.Owner)
+		owner.Valid = true
+	}
+	intervalMillis := int64(timer.Interval / time.Millisecond)
+
+	result, err := w.db.Exec("UPDATE timer SET due = $1, interval_ms = $2 WHERE id = $3",
+		timer.Due, intervalMillis, timer.Id)
+	if err == nil {
+		var n int64
+		n, err = result.RowsAffected()
+		if err == nil && n == 0 {
+			_, err = w.db.Exec("INSERT INTO timer (id, thing, owner, callback, due, interval_ms) VALUES ($1, $2, $3, $4, $5, $6)",
+				timer.Id, timer.Thing, owner, timer.Function, timer.Due, intervalMillis)
+		}
+	}
+	if err != nil {
+		log.Println("Error saving timer", timer.Id, ":", err.Error())
+		return databaseError(err)
+	}
+	return nil
+}
+
+func (w *DatabaseWorld) DeleteTimer(id int64) error {
+	_, err := w.db.Exec("DELETE FROM timer WHERE id = $1", id)
+	if err != nil {
+		log.Println("Error deleting timer", id, ":", err.Error())
+		return databaseError(err)
+	}
+	return nil
+}
+
+func (w *DatabaseWorld) AllTimers() ([]*Timer, error) {
+	rows, err := w.db.Query("SELECT id, thing, owner, callback, due, interval_ms FROM timer ORDER BY id")
+	if err != nil {
+		log.Println("Error listing timers:", err.Error())
+		return nil, databaseError(err)
+	}
+	defer rows.Close()
+
+	var timers []*Timer
+	for rows.Next() {
+		timer := &Timer{}
+		var owner sql.NullInt64
+		var intervalMillis int64
+		err = rows.Scan(&timer.Id, &timer.Thing, &owner, &timer.Function, &timer.Due, &intervalMillis)
+		if err != nil {
+			log.Println("Error listing timers:", err.Error())
+			return nil, databaseError(err)
+		}
+		timer.Owner = ThingId(owner.Int64)
+		timer.Interval = time.Duration(intervalMillis) * time.Millisecond
+		timers = append(timers, timer)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Println("Error listing timers:", err.Error())
+		return nil, databaseError(err)
+	}
+	return timers, nil
+}
+
+// SaveScriptError adds the error to its thing's error log, setting its Id, and forgets the thing's oldest errors beyond MaxScriptErrors.
+func (w *DatabaseWorld) SaveScriptError(scriptErr *ScriptError) error {
+	var player sql.NullInt64
+	if scriptErr.Player != 0 {
+		player.Int64 = int64(scriptErr.Player)
+		player.Valid = true
+	}
+
+	row := w.db.QueryRow("INSERT INTO script_error (thing, handler, line, library, message, traceback, player, created) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		scriptErr.Thing, scriptErr.Function, scriptErr.Line, scriptErr.Library, scriptErr.Message, strings.Join(scriptErr.Traceback, "\n"), player, scriptErr.Created)
+	err := row.Scan(&scriptErr.Id)
+	if err == nil {
+		_, err = w.db.Exec("DELETE FROM script_error WHERE thing = $1 AND id NOT IN (SELECT id FROM script_error WHERE thing = $1 ORDER BY id DESC LIMIT $2)",
+			scriptErr.Thing, MaxScriptErrors)
+	}
+	if err != nil {
+		log.Println("Error saving script error for thing", scriptErr.Thing, ":", err.Error())
+		return databaseError(err)
+	}
+	return nil
+}
+
+// ScriptErrors finds the error log of the thing with the given id, newest first.
+func (w *DatabaseWorld) ScriptErrors(id ThingId) ([]*ScriptError, error) {
+	rows, err := w.db.Query("SELECT id, thing, handler, line, library, message, traceback, player, created FROM script_error WHERE thing = $1 ORDER BY id DESC", id)
+	if err != nil {
+		log.Println("Error finding script errors of thing", id, ":", err.Error())
+		return nil, databaseError(err)
+	}
+	defer rows.Close()
+
+	var scriptErrs []*ScriptError
+	for rows.Next() {
+		scriptErr := &ScriptError{}
+		var traceback string
+		var player sql.NullInt64
+		err = rows.Scan(&scriptErr.Id, &scriptErr.Thing, &scriptErr.Function, &scriptErr.Line, &scriptErr.Library, &scriptErr.Message, &traceback, &player, &scriptErr.Created)
+		if err != nil {
+			log.Println("Error finding script errors of thing", id, ":", err.Error())
+			return nil, databaseError(err)
+		}
+		if traceback != "" {
+			scriptErr.Traceback = strings.Split(traceback, "\n")
+		}
+		scriptErr.Player = ThingId(player.Int64)
+		scriptErrs = append(scriptErrs, scriptErr)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Println("Error finding script errors of thing", id, ":", err.Error())
+		return nil, databaseError(err)
+	}
+	return scriptErrs, nil
+}
+
+func (w *DatabaseWorld) ClearScriptErrors(id ThingId) error {
+	_, err := w.db.Exec("DELETE FROM script_error WHERE thing = $1", id)
+	if err != nil {
+		log.Println("Error clearing script errors of thing", id, ":", err.Error())
+		return databaseError(err)
+	}
+	return nil
+}
+
+func (w *DatabaseWorld) AllThingIds() ([]ThingId, error) {
+	rows, err := w.db.Query("SELECT id FROM thing ORDER BY id")
+	if err != nil {
+		log.Println("Error listing things:", err.Error())
+		return nil, databaseError(err)
+	}
+	defer rows.Close()
+
+	var ids []ThingId
+	for rows.Next() {
+		var id ThingId
+		err = rows.Scan(&id)
+		if err != nil {
+			log.Println("Error listing things:", err.Error())
+			return nil, databaseError(err)
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Println("Error listing things:", err.Error())
+		return nil, databaseError(err)
+	}
+	return ids, nil
+}
+
+func (w *DatabaseWorld) FindThings(q *ThingQuery) ([]ThingId, error) {
+	search := &sqlSearch{placeholder: func(n int) string { return fmt.Sprintf("$%d", n) }}
+	search.addCommon(q)
+	if q.TableKey != "" && q.TableValue == nil {
+		search.add("tabledata ? %s", q.TableKey)
+	} else if q.TableKey != "" {
+		// Containment can use the GIN index on tabledata.
+		contained, err := EncodeTable(map[string]interface{}{q.TableKey: q.TableValue})
+		if err != nil {
+			return nil, &StoreError{StoreConstraintViolation, err}
+		}
+		search.add("tabledata @> %s::jsonb", string(contained))
+	}
+
+	rows, err := w.db.Query(search.query(q.ResultLimit()), search.args...)
+	if err != nil {
+		log.Println("Error finding things:", err.Error())
+		return nil, databaseError(err)
+	}
+	defer rows.Close()
+
+	var ids []ThingId
+	for rows.Next() {
+		var id ThingId
+		err = rows.Scan(&id)
+		if err != nil {
+			log.Println("Error finding things:", err.Error())
+			return nil, databaseError(err)
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Println("Error finding things:", err.Error())
+		return nil, databaseError(err)
+	}
+	return ids, nil
+}
+
+// pruneRevisionsInTx forgets all but the newest MaxThingRevisions revisions of the things revs are of, with the statement, which should take a thing's id & MaxThingRevisions.
+func pruneRevisionsInTx(tx *sql.Tx, statement string, revs []*ThingRevision) error {
+	pruned := make(map[ThingId]bool)
+	for _, rev := range revs {
+		if pruned[rev.Thing] {
+			continue
+		}
+		pruned[rev.Thing] = true
+		_, err := tx.Exec(statement, rev.Thing, MaxThingRevisions)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// childActionsInTx finds the ids of the actions in the thing with the given id, which are destroyed along with it, using the query, which should take the thing's id.
+func childActionsInTx(tx *sql.Tx, query string, id ThingId) (ThingIdList, error) {
+	rows, err := tx.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids ThingIdList
+	for rows.Next() {
+		var actionId ThingId
+		err = rows.Scan(&actionId)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, actionId)
+	}
+	return ids, rows.Err()
+}
+
+// removeTableRefsInTx takes references to the things with the given ids out of the table data of the things the selectQuery finds, saving the changed tables with updateStatement. The query should find (id, tabledata) rows, and the statement take the new tabledata & id.
+func removeTableRefsInTx(tx *sql.Tx, selectQuery, updateStatement string, ids ThingIdList) error {
+	changed := make(map[ThingId]string)
+	rows, err := tx.Query(selectQuery)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var rowId ThingId
+		var tabledata []byte
+		err = rows.Scan(&rowId, &tabledata)
+		if err != nil {
+			break
+		}
+		table, err := DecodeTable(tabledata)
+		if err != nil {
+			// There's no telling what's in there, so leave it be.
+			log.Println("Error reading table data of thing", rowId, "to remove references to", ids, ":", err.Error())
+			continue
+		}
+		removed := false
+		for _, id := range ids {
+			if RemoveThingRefs(table, id) {
+				removed = true
+			}
+		}
+		if !removed {
+			continue
+		}
+		tabletext, err := EncodeTable(table)
+		if err != nil {
+			log.Println("Error writing table data of thing", rowId, "to remove references to", ids, ":", err.Error())
+			continue
+		}
+		changed[rowId] = string(tabletext)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for rowId, tabletext := range changed {
+		_, err = tx.Exec(updateStatement, tabletext, rowId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CacheStats counts how well an ActiveWorld's in-memory cache is working.
+type CacheStats struct {
+	Things      int
+	Missing     int
+	Hits        uint64
+	MissingHits uint64
+	Misses      uint64
+	Evictions   uint64
+	// Unsaved is how many things have changes waiting to be saved.
+	Unsaved int
 }
 
+// worldLock serializes everything that reads or changes live Things. See WithWorld.
+var worldLock sync.Mutex
+
+// WithWorld calls f while holding the world lock. Things from World are shared by every connected player & web request, so anything that looks at or changes them (their fields, Contents & Tables, and the Lua programs that run against them) must happen inside WithWorld: each game command, web request and account registration runs wholly inside it, one at a time. The lock is not reentrant, so f must not call WithWorld again. (ActiveWorld's own mutex only guards its cache bookkeeping, so the stores remain safe to call from anywhere.)
+func WithWorld(f func()) {
+	worldLock.Lock()
+	defer worldLock.Unlock()
+	f()
+}
+
+// ActiveWorld is the WorldStore the game uses. It keeps recently used things in memory, in front of the Next store where they're saved. Make ActiveWorlds with NewActiveWorld.
+//
+// If SavePeriod is set, SaveThing only marks a thing as changed, and the changed things are saved together every SavePeriod by KeepSaving (and when asked with SaveDirty). Creating, moving & destroying things still happen in the Next store right away, so those (and new accounts, which are made of them) are never lost.
 type ActiveWorld struct {
 	sync.Mutex
 	Things map[ThingId]*Thing
 	Next   WorldStore
+
+	// MaxThings is how many things to keep in memory before forgetting the least recently used ones. Things with connected clients, or whose programs have anonymous timers waiting, are never forgotten, so there can be more. Zero means no limit.
+	MaxThings int
+	// MissingExpiry is how long to remember that a thing doesn't exist before asking Next again, in case it was made outside the mess.
+	MissingExpiry time.Duration
+	// SavePeriod is how often to save changed things. Zero means save each change right away.
+	SavePeriod time.Duration
+
+	stats   CacheStats
+	missing map[ThingId]time.Time
+	recent  *list.List
+	recents map[ThingId]*list.Element
+
+	// dirty is which things have unsaved changes, and the number of the latest change to each (counted by changes), so a save can tell if a thing changed again while it was being saved.
+	dirty   map[ThingId]int
+	changes int
+	// revisions are the things' revisions waiting to be saved with their changes.
+	revisions []*ThingRevision
+	// saving is held while writing to the Next store, so saves of changed things aren't written over the top of moves & destroys made in the meantime.
+	saving sync.Mutex
 }
 
-func (w *ActiveWorld) ThingForId(id ThingId) (thing *Thing) {
+func NewActiveWorld(next WorldStore, maxThings int, missingExpiry time.Duration, savePeriod time.Duration) *ActiveWorld {
+	return &ActiveWorld{
+		Things:        make(map[ThingId]*Thing),
+		Next:          next,
+		MaxThings:     maxThings,
+		MissingExpiry: missingExpiry,
+		SavePeriod:    savePeriod,
+		missing:       make(map[ThingId]time.Time),
+		recent:        list.New(),
+		recents:       make(map[ThingId]*list.Element),
+		dirty:         make(map[ThingId]int),
+	}
+}
+
+// remember puts thing in the cache as the most recently used thing. The world must be locked.
+func (w *ActiveWorld) remember(thing *Thing) {
+	w.Things[thing.Id] = thing
+	delete(w.missing, thing.Id)
+
+	if element, ok := w.recents[thing.Id]; ok {
+		w.recent.MoveToFront(element)
+	} else {
+		w.recents[thing.Id] = w.recent.PushFront(thing.Id)
+	}
+
+	if w.MaxThings <= 0 {
+		return
+	}
+	for element := w.recent.Back(); element != nil && w.MaxThings < len(w.Things); {
+		prev := element.Prev()
+		id := element.Value.(ThingId)
+		_, dirty := w.dirty[id]
+		if cached := w.Things[id]; cached.Client == nil && !dirty && !cached.Program.hasTimers() && id != thing.Id {
+			w.forget(id)
+			w.stats.Evictions++
+		}
+		element = prev
+	}
+}
+
+// forget takes the thing with the given id out of the cache, dropping any unsaved changes, and closes its program, as it's loaded anew with the thing. The world must be locked.
+func (w *ActiveWorld) forget(id ThingId) {
+	if thing, ok := w.Things[id]; ok && thing.Program != nil {
+		thing.Program.Close()
+	}
+	delete(w.Things, id)
+	delete(w.missing, id)
+	delete(w.dirty, id)
+	if element, ok := w.recents[id]; ok {
+		w.recent.Remove(element)
+		delete(w.recents, id)
+	}
+}
+
+func (w *ActiveWorld) ThingForId(id ThingId) (*Thing, error) {
 	w.Lock()
 	defer w.Unlock()
 
 	if id == 0 {
-		return
+		return nil, notFoundError("there is no thing #0")
 	}
 
 	thing, ok := w.Things[id]
 	if ok {
-		return
+		w.stats.Hits++
+		w.recent.MoveToFront(w.recents[id])
+		return thing, nil
+	}
+	if expires, ok := w.missing[id]; ok {
+		if time.Now().Before(expires) {
+			w.stats.MissingHits++
+			return nil, notFoundError("there is no thing #%d", id)
+		}
+		delete(w.missing, id)
 	}
 
-	thing = w.Next.ThingForId(id)
-	w.Things[id] = thing
+	w.stats.Misses++
+	thing, err := w.Next.ThingForId(id)
+	if err != nil {
+		// Remember things that don't exist for a while, but try again next time if the store was only unavailable.
+		if StoreFailureOf(err) == StoreNotFound {
+			w.missing[id] = time.Now().Add(w.MissingExpiry)
+		}
+		return nil, err
+	}
+	w.remember(thing)
 
-	return
+	return thing, nil
 }
 
-func (w *ActiveWorld) CreateThing(name string, tt ThingType, creator *Thing, parent *Thing) (thing *Thing) {
-	thing = w.Next.CreateThing(name, tt, creator, parent)
-	if thing == nil {
-		return
+func (w *ActiveWorld) CreateThing(name string, tt ThingType, creator *Thing, parent *Thing) (*Thing, error) {
+	thing, err := w.Next.CreateThing(name, tt, creator, parent)
+	if err != nil {
+		return nil, err
 	}
 
 	log.Println("Created a thing", thing, ", adding to parent's in-memory contents")
 	parent.Contents = append(parent.Contents, thing.Id)
-	w.Things[thing.Id] = thing
-	return
+
+	w.Lock()
+	w.remember(thing)
+	w.Unlock()
+	return thing, nil
+}
+
+// CacheStats reports how many things are in memory & how often they've been found there.
+func (w *ActiveWorld) CacheStats() CacheStats {
+	w.Lock()
+	defer w.Unlock()
+
+	stats := w.stats
+	stats.Things = len(w.Things)
+	stats.Missing = len(w.missing)
+	stats.Unsaved = len(w.dirty)
+	return stats
 }
 
-func (w *ActiveWorld) MoveThing(thing *Thing, target *Thing) (ok bool) {
-	if !w.Next.MoveThing(thing, target) {
-		return false
+// Flush forgets the thing with the given id, so it's loaded fresh from the Next store the next time it's needed. Its unsaved changes are saved first. Things with connected clients can't be flushed, since the client's game would keep using the old copy; reload them instead.
+func (w *ActiveWorld) Flush(id ThingId) error {
+	w.saving.Lock()
+	defer w.saving.Unlock()
+	w.Lock()
+	defer w.Unlock()
+
+	thing, ok := w.Things[id]
+	if ok && thing.Client != nil {
+		return &StoreError{StoreConstraintViolation, errors.New("it's in use by a connected player, so reload it instead")}
 	}
+	if _, dirty := w.dirty[id]; ok && dirty {
+		err := w.Next.SaveThing(thing)
+		if err != nil {
+			return err
+		}
+	}
+	w.forget(id)
+	return nil
+}
 
-	oldParent := w.ThingForId(thing.Parent)
+// Reload replaces the in-memory copy of the thing with the given id with a fresh one from the Next store, discarding any unsaved changes. The fresh data is copied into the existing in-memory Thing, so anything holding onto it sees the reloaded version.
+func (w *ActiveWorld) Reload(id ThingId) (*Thing, error) {
+	w.saving.Lock()
+	defer w.saving.Unlock()
 
-	for i, c := range oldParent.Contents {
-		if c != thing.Id {
-			continue
+	fresh, err := w.Next.ThingForId(id)
+
+	w.Lock()
+	defer w.Unlock()
+
+	if err != nil {
+		if StoreFailureOf(err) == StoreNotFound {
+			if thing, ok := w.Things[id]; !ok || thing.Client == nil {
+				w.forget(id)
+			}
 		}
+		return nil, err
+	}
 
-		// It matched, so splice out the i'th element.
-		copy(oldParent.Contents[i:], oldParent.Contents[i+1:])
-		oldParent.Contents = oldParent.Contents[:len(oldParent.Contents)-1]
-		log.Println("Removed", thing, "from parent", oldParent, ", remaining things:", oldParent.Contents)
-		break
+	thing, ok := w.Things[id]
+	if !ok {
+		w.remember(fresh)
+		return fresh, nil
+	}
+
+	client := thing.Client
+	if thing.Program != nil {
+		thing.Program.Close()
+	}
+	*thing = *fresh
+	thing.Client = client
+	delete(w.dirty, id)
+	kept := w.revisions[:0]
+	for _, rev := range w.revisions {
+		if rev.Thing != id {
+			kept = append(kept, rev)
+		}
+	}
+	w.revisions = kept
+	w.remember(thing)
+	return thing, nil
+}
+
+func (w *ActiveWorld) MoveThing(thing *Thing, target *Thing) error {
+	err := thing.CheckMoveTo(target)
+	if err != nil {
+		log.Println("Refusing to move thing", thing.Id, ":", err.Error())
+		return err
+	}
+
+	w.saving.Lock()
+	err = w.Next.MoveThing(thing, target)
+	w.saving.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Things can be nowhere (such as the first place), so there may be no old parent.
+	if oldParent, err := w.ThingForId(thing.Parent); err == nil {
+		for i, c := range oldParent.Contents {
+			if c != thing.Id {
+				continue
+			}
+
+			// It matched, so splice out the i'th element.
+			copy(oldParent.Contents[i:], oldParent.Contents[i+1:])
+			oldParent.Contents = oldParent.Contents[:len(oldParent.Contents)-1]
+			log.Println("Removed", thing, "from parent", oldParent, ", remaining things:", oldParent.Contents)
+			break
+		}
 	}
 
 	thing.Parent = target.Id
 	target.Contents = append(target.Contents, thing.Id)
 
-	return true
+	return nil
+}
+
+func (w *ActiveWorld) SaveThing(thing *Thing) error {
+	return w.SaveThingBy(thing, 0)
+}
+
+// SaveThingBy saves the thing like SaveThing, recording in its history that the player with editorId changed it (or no player, if editorId is 0).
+func (w *ActiveWorld) SaveThingBy(thing *Thing, editorId ThingId) error {
+	rev, err := NewRevision(thing, editorId)
+	if err != nil {
+		log.Println("Error recording revision of thing", thing.Id, ":", err.Error())
+		return &StoreError{StoreConstraintViolation, err}
+	}
+
+	if w.SavePeriod <= 0 {
+		w.saving.Lock()
+		err := w.Next.SaveThing(thing)
+		if err == nil {
+			err = w.Next.SaveRevisions([]*ThingRevision{rev})
+		}
+		w.saving.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	// This must be the newest version of thing in memory. Make sure it's the one we're giving out from now on (just in case).
+	w.Lock()
+	if w.SavePeriod > 0 {
+		w.changes++
+		w.dirty[thing.Id] = w.changes
+		w.addRevision(rev)
+	}
+	w.remember(thing)
+	w.Unlock()
+	return nil
 }
 
-func (w *ActiveWorld) SaveThing(thing *Thing) (ok bool) {
-	if w.Next.SaveThing(thing) {
-		// This must be the newest version of thing in memory. Make sure it's the one we're giving out from now on (just in case).
-		w.Things[thing.Id] = thing
-		return true
+// addRevision queues rev to be saved with the next batch of changes. If the thing's last unsaved revision was by the same editor, rev replaces it, so a flurry of changes is one revision. The world must be locked.
+func (w *ActiveWorld) addRevision(rev *ThingRevision) {
+	for i := len(w.revisions) - 1; 0 <= i; i-- {
+		if w.revisions[i].Thing != rev.Thing {
+			continue
+		}
+		if w.revisions[i].Editor == rev.Editor {
+			w.revisions[i] = rev
+			return
+		}
+		break
 	}
-	return false
+	w.revisions = append(w.revisions, rev)
+}
+
+func (w *ActiveWorld) SaveRevisions(revs []*ThingRevision) error {
+	w.saving.Lock()
+	defer w.saving.Unlock()
+	return w.Next.SaveRevisions(revs)
+}
+
+func (w *ActiveWorld) SaveTimer(timer *Timer) error {
+	w.saving.Lock()
+	defer w.saving.Unlock()
+	return w.Next.SaveTimer(timer)
+}
+
+func (w *ActiveWorld) DeleteTimer(id int64) error {
+	w.saving.Lock()
+	defer w.saving.Unlock()
+	return w.Next.DeleteTimer(id)
+}
+
+func (w *ActiveWorld) AllTimers() ([]*Timer, error) {
+	return w.Next.AllTimers()
+}
+
+func (w *ActiveWorld) SaveScriptError(scriptErr *ScriptError) error {
+	w.saving.Lock()
+	defer w.saving.Unlock()
+	return w.Next.SaveScriptError(scriptErr)
+}
+
+func (w *ActiveWorld) ScriptErrors(id ThingId) ([]*ScriptError, error) {
+	return w.Next.ScriptErrors(id)
+}
+
+func (w *ActiveWorld) ClearScriptErrors(id ThingId) error {
+	w.saving.Lock()
+	defer w.saving.Unlock()
+	return w.Next.ClearScriptErrors(id)
+}
+
+// ThingRevisions finds the history of the thing with the given id, newest first. Unsaved changes are saved first so they're included, so the world must be locked (see WithWorld).
+func (w *ActiveWorld) ThingRevisions(id ThingId) ([]*ThingRevision, error) {
+	err := w.SaveDirty()
+	if err != nil {
+		return nil, err
+	}
+	return w.Next.ThingRevisions(id)
+}
+
+// FindThings searches the Next store, then checks the things with unsaved changes against the query, so they're found as they are now without saving them first. Like SaveDirty, it must be called with the world locked.
+func (w *ActiveWorld) FindThings(q *ThingQuery) ([]ThingId, error) {
+	ids, err := w.Next.FindThings(q)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if len(w.dirty) == 0 {
+		return ids, nil
+	}
+
+	// Stores find the first matches by id, so if the store found all it could, changed things after its last match may come after matches it didn't find.
+	limit := q.ResultLimit()
+	var last ThingId
+	if len(ids) == limit {
+		last = ids[len(ids)-1]
+	}
+
+	found := make(map[ThingId]bool)
+	for _, id := range ids {
+		found[id] = true
+	}
+	for id := range w.dirty {
+		matches := q.Matches(w.Things[id])
+		if matches && !found[id] && (last == 0 || id < last) {
+			ids = append(ids, id)
+		} else if !matches && found[id] {
+			ids = ThingIdList(ids).Without(id)
+		}
+	}
+
+	sort.Sort(thingIdsById(ids))
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+// unsavedChange is a copy of a changed thing to save, and which change it was copied at.
+type unsavedChange struct {
+	thing  *Thing
+	change int
+}
+
+// copyForSave copies the parts of thing that stores save, so the copy can be saved while the game keeps changing the original.
+func copyForSave(thing *Thing) (*Thing, error) {
+	tabletext, err := EncodeTable(thing.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	saved := NewThing()
+	saved.Table, err = DecodeTable(tabletext)
+	if err != nil {
+		return nil, err
+	}
+	saved.Id = thing.Id
+	saved.Type = thing.Type
+	saved.Name = thing.Name
+	saved.Parent = thing.Parent
+	saved.Creator = thing.Creator
+	saved.Created = thing.Created
+	saved.Owner = thing.Owner
+	saved.Superuser = thing.Superuser
+	saved.Wizard = thing.Wizard
+	saved.AdminList = copyThingIdList(thing.AdminList)
+	saved.AllowList = copyThingIdList(thing.AllowList)
+	saved.DenyList = copyThingIdList(thing.DenyList)
+	if thing.Program != nil {
+		saved.Program = &ThingProgram{Text: thing.Program.Text}
+	}
+	return saved, nil
+}
+
+// takeUnsaved copies all the things with unsaved changes, takes the revisions waiting to be saved, and starts saving them. The world must be locked (see WithWorld), but it needn't be for the saveChanges call that must follow.
+func (w *ActiveWorld) takeUnsaved() ([]unsavedChange, []*ThingRevision) {
+	w.saving.Lock()
+	w.Lock()
+	defer w.Unlock()
+
+	revs := w.revisions
+	w.revisions = nil
+
+	var changes []unsavedChange
+	for id, change := range w.dirty {
+		saved, err := copyForSave(w.Things[id])
+		if err != nil {
+			log.Println("Error copying thing", id, "to save, so discarding its changes:", err.Error())
+			delete(w.dirty, id)
+			continue
+		}
+		changes = append(changes, unsavedChange{saved, change})
+	}
+	return changes, revs
+}
+
+// saveChanges saves the changes & revisions from takeUnsaved to the Next store, in one transaction if it can.
+func (w *ActiveWorld) saveChanges(changes []unsavedChange, revs []*ThingRevision) error {
+	defer w.saving.Unlock()
+	if len(changes) == 0 && len(revs) == 0 {
+		return nil
+	}
+
+	things := make([]*Thing, len(changes))
+	for i, change := range changes {
+		things[i] = change.thing
+	}
+
+	var err error
+	saver, ok := w.Next.(BatchSaver)
+	if ok {
+		err = saver.SaveThings(things)
+		if err != nil {
+			log.Println("Couldn't save", len(things), "changed things together, so saving them one at a time:", err.Error())
+		}
+	}
+	refused := make(map[ThingId]error)
+	if !ok || err != nil {
+		err = nil
+		for _, thing := range things {
+			thingErr := w.Next.SaveThing(thing)
+			if thingErr != nil {
+				refused[thing.Id] = thingErr
+				if err == nil {
+					err = thingErr
+				}
+			}
+		}
+	}
+
+	// Save the revisions after the changes they record, so the history doesn't have changes that weren't really saved.
+	var retryRevs, savedRevs []*ThingRevision
+	for _, rev := range revs {
+		if saveErr, ok := refused[rev.Thing]; ok {
+			if StoreFailureOf(saveErr) == StoreUnavailable {
+				retryRevs = append(retryRevs, rev)
+			}
+			continue
+		}
+		savedRevs = append(savedRevs, rev)
+	}
+	if len(savedRevs) > 0 && w.Next.SaveRevisions(savedRevs) != nil {
+		for _, rev := range savedRevs {
+			revErr := w.Next.SaveRevisions([]*ThingRevision{rev})
+			if revErr == nil {
+				continue
+			}
+			if StoreFailureOf(revErr) == StoreUnavailable {
+				retryRevs = append(retryRevs, rev)
+			} else {
+				log.Println("Discarding revision of thing", rev.Thing, "the store refused:", revErr.Error())
+			}
+			if err == nil {
+				err = revErr
+			}
+		}
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	for _, change := range changes {
+		id := change.thing.Id
+		if current, ok := w.dirty[id]; !ok || current != change.change {
+			// It was changed again (or destroyed) while we were saving, so leave it for next time.
+			continue
+		}
+		// Try again next time if the store was only unavailable, but changes it refused are never going to save.
+		if saveErr, ok := refused[id]; ok {
+			if StoreFailureOf(saveErr) == StoreUnavailable {
+				continue
+			}
+			log.Println("Discarding changes to thing", id, "the store refused:", saveErr.Error())
+		}
+		delete(w.dirty, id)
+	}
+
+	// Put back the revisions to try again next time.
+	w.revisions = append(retryRevs, w.revisions...)
+	return err
+}
+
+// SaveDirty saves all the things with unsaved changes now. The world must be locked (see WithWorld).
+func (w *ActiveWorld) SaveDirty() error {
+	return w.saveChanges(w.takeUnsaved())
+}
+
+// KeepSaving saves the things with unsaved changes every SavePeriod, forever. The game keeps running while the changes are written.
+func (w *ActiveWorld) KeepSaving() {
+	if w.SavePeriod <= 0 {
+		return
+	}
+	for _ = range time.Tick(w.SavePeriod) {
+		var changes []unsavedChange
+		var revs []*ThingRevision
+		WithWorld(func() {
+			changes, revs = w.takeUnsaved()
+		})
+		err := w.saveChanges(changes, revs)
+		if err != nil {
+			log.Println("Error saving changed things:", err.Error())
+		}
+	}
+}
+
+func (w *ActiveWorld) DestroyThing(thing *Thing) error {
+	if thing.Id == 1 {
+		log.Println("Refusing to destroy the first place, where new players start")
+		return &StoreError{StoreConstraintViolation, errors.New("the first place, where new players start, can't be destroyed")}
+	}
+
+	// Load the contents first, so we know which are actions that will be destroyed too.
+	var contents []*Thing
+	for _, contentId := range thing.Contents {
+		content, err := w.ThingForId(contentId)
+		if err == nil {
+			contents = append(contents, content)
+		}
+	}
+
+	w.saving.Lock()
+	err := w.Next.DestroyThing(thing)
+	w.saving.Unlock()
+	if err != nil {
+		return err
+	}
+
+	w.Lock()
+
+	// Update the in-memory things that were affected. Things we haven't loaded yet will load with the changes anyway.
+	homeId := thing.RecycleHome()
+	home := w.Things[homeId]
+	var displaced []*Thing
+	gone := ThingIdList{thing.Id}
+	for _, content := range contents {
+		if content.Type == ActionThing {
+			w.forget(content.Id)
+			gone = append(gone, content.Id)
+			continue
+		}
+
+		content.Parent = homeId
+		if home != nil {
+			home.Contents = append(home.Contents, content.Id)
+		}
+		if content.Client != nil {
+			displaced = append(displaced, content)
+		}
+	}
+
+	for _, other := range w.Things {
+		if other.Id == thing.Parent {
+			other.Contents = other.Contents.Without(thing.Id)
+		}
+		other.AdminList = other.AdminList.Without(thing.Id)
+		other.AllowList = other.AllowList.Without(thing.Id)
+		other.DenyList = other.DenyList.Without(thing.Id)
+		if other.Creator == thing.Id {
+			other.Creator = 0
+		}
+		if other.Owner == thing.Id {
+			other.Owner = 0
+		}
+		for _, id := range gone {
+			RemoveThingRefs(other.Table, id)
+		}
+	}
+
+	w.forget(thing.Id)
+	w.Unlock()
+
+	for _, content := range displaced {
+		content.Client.Send(fmt.Sprintf("%s vanishes around you, and you find yourself somewhere else.", thing.Name))
+	}
+
+	log.Println("Destroyed thing", thing)
+	return nil
 }
@@ -0,0 +1,211 @@
+package mess
+
+import (
+	"github.com/gorilla/context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useActiveWorld makes a new ActiveWorld, saving changes every savePeriod (or right away, if it's 0), in front of a new MemoryWorld, for the test to use as World & Accounts. Call the returned func to put back the old ones.
+func useActiveWorld(savePeriod time.Duration) (*ActiveWorld, *MemoryWorld, func()) {
+	mem := NewMemoryWorld()
+	active := NewActiveWorld(mem, 0, time.Minute, savePeriod)
+	oldWorld, oldAccounts := World, Accounts
+	World, Accounts = active, mem
+	return active, mem, func() {
+		World, Accounts = oldWorld, oldAccounts
+	}
+}
+
+// TestConcurrentMovesSavesAndWebEdits is for running with -race: it moves, saves & edits things through the web from several goroutines at once, while their changes are saved in the background, as the server does.
+func TestConcurrentMovesSavesAndWebEdits(t *testing.T) {
+	active, mem, restore := useActiveWorld(time.Hour)
+	defer restore()
+
+	passwordHash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var account *Account
+	var origin, other, player, lamp *Thing
+	WithWorld(func() {
+		account, err = Accounts.CreateAccount("alice", passwordHash)
+		if err != nil {
+			return
+		}
+		origin = GetThing(1)
+		player = GetThing(account.Character)
+		other, err = World.CreateThing("Room Two", PlaceThing, player, origin)
+		if err != nil {
+			return
+		}
+		lamp, err = World.CreateThing("Lamp", RegularThing, player, origin)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const rounds = 100
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				f(i)
+			}
+		}()
+	}
+
+	// Two movers push the lamp & the player back and forth.
+	for _, mover := range []*Thing{lamp, player} {
+		mover := mover
+		run(func(i int) {
+			WithWorld(func() {
+				target := origin
+				if i%2 == 0 {
+					target = other
+				}
+				if err := mover.MoveTo(target); err != nil {
+					t.Errorf("couldn't move %s to %s: %s", mover.Name, target.Name, err.Error())
+				}
+			})
+		})
+	}
+
+	// A game command changes the player's table.
+	run(func(i int) {
+		WithWorld(func() {
+			player.Table["moves"] = float64(i)
+			if err := World.SaveThing(player); err != nil {
+				t.Errorf("couldn't save the player: %s", err.Error())
+			}
+		})
+	})
+
+	// A web page edits the lamp's table.
+	run(func(i int) {
+		form := url.Values{}
+		form.Set("updated_data", `{"description": "A brass lamp."}`)
+		form.Set("deleted_data", `{"lit": true}`)
+		r, _ := http.NewRequest("POST", "/thing/table", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		context.Set(r, ContextKeyAccount, account)
+		context.Set(r, ContextKeyThing, lamp)
+		WithWorld(func() {
+			WebThingTable(w, r)
+		})
+		context.Clear(r)
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("editing the lamp's table got status %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	// Changes are saved in the background, and the store is read without the world lock, as the stores allow.
+	done := make(chan bool)
+	var saverWg sync.WaitGroup
+	saverWg.Add(1)
+	go func() {
+		defer saverWg.Done()
+		for {
+			var changes []unsavedChange
+			var revs []*ThingRevision
+			WithWorld(func() {
+				changes, revs = active.takeUnsaved()
+			})
+			if err := active.saveChanges(changes, revs); err != nil {
+				t.Errorf("couldn't save changes: %s", err.Error())
+			}
+			if _, err := mem.ThingForId(lamp.Id); err != nil {
+				t.Errorf("couldn't read the lamp from the store: %s", err.Error())
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+	saverWg.Wait()
+
+	WithWorld(func() {
+		if err := active.SaveDirty(); err != nil {
+			t.Fatal(err)
+		}
+		for _, thing := range []*Thing{lamp, player} {
+			if thing.Parent != origin.Id {
+				t.Errorf("%s ended up in #%d, not the first place", thing.Name, thing.Parent)
+			}
+			inOrigin, inOther := false, false
+			for _, id := range origin.Contents {
+				inOrigin = inOrigin || id == thing.Id
+			}
+			for _, id := range other.Contents {
+				inOther = inOther || id == thing.Id
+			}
+			if !inOrigin || inOther {
+				t.Errorf("%s is in the first place's contents: %v, and the second's: %v", thing.Name, inOrigin, inOther)
+			}
+		}
+	})
+
+	saved, err := mem.ThingForId(lamp.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Parent != origin.Id || saved.Table["description"] != "A brass lamp." {
+		t.Errorf("the lamp was saved in #%d with table %v", saved.Parent, saved.Table)
+	}
+	saved, err = mem.ThingForId(player.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Table["moves"] != float64(rounds-1) {
+		t.Errorf("the player was saved with %v moves, not %d", saved.Table["moves"], rounds-1)
+	}
+}
+
+// TestActiveWorldKeepsThingsWithTimers checks that the cache doesn't forget things whose programs have anonymous timers waiting, as the timers would be lost with their programs.
+func TestActiveWorldKeepsThingsWithTimers(t *testing.T) {
+	mem := NewMemoryWorld()
+	origin, err := mem.ThingForId(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []ThingId
+	for _, name := range []string{"clock", "lamp", "box"} {
+		thing, err := mem.CreateThing(name, RegularThing, nil, origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, thing.Id)
+	}
+
+	active := NewActiveWorld(mem, 1, time.Minute, 0)
+	clock, err := active.ThingForId(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Program = &ThingProgram{Text: "world.after(60, function() end)", timers: 1}
+	for _, id := range ids[1:] {
+		if _, err := active.ThingForId(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if active.Things[clock.Id] != clock {
+		t.Error("the clock with a timer waiting was forgotten")
+	}
+	if _, ok := active.Things[ids[1]]; ok {
+		t.Error("the lamp wasn't forgotten to make room")
+	}
+}